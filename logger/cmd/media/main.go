@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/zxeenu/heavy-telegram-bot/logger/internal/media"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	replayDLQ := flag.Bool("replay-dlq", false, "move every job on the dead-letter queue back to its media queue, then exit")
+	limit := flag.Int("limit", 0, "with -replay-dlq, replay at most this many jobs (0 means all)")
+	verify := flag.Bool("verify", false, "re-hash every stored file against its recorded SHA-256, then exit")
+	flag.Parse()
+
+	// The env file has to be applied first so it can feed every setting,
+	// including the logger's.
+	envErr := media.LoadEnvFile()
+	logger, logErr := media.NewLogger(os.Stderr)
+
+	cfg, err := media.LoadConfig()
+	if err = errors.Join(envErr, logErr, err, cfg.Validate()); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  - %s\n", line)
+		}
+		return 2
+	}
+	slog.SetDefault(logger)
+	slog.Debug("loaded rabbitmq config", "rabbitmq", cfg.Rabbit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			slog.Info("shutting down", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if *replayDLQ {
+		return replay(ctx, cfg, *limit)
+	}
+
+	storage, err := media.NewStorage(ctx, cfg.Storage)
+	if err != nil {
+		slog.Error("storage setup failed", "error", err)
+		return 1
+	}
+
+	if *verify {
+		return verifyStorage(ctx, storage)
+	}
+
+	m := metrics.New()
+	httpClient := media.NewHTTPClient(cfg.HTTP)
+	err = media.Run(ctx, media.Deps{
+		Config:    cfg,
+		Broker:    media.NewReconnectingConnection(cfg.Rabbit),
+		Telegram:  media.NewTelegramClient(cfg.Telegram, httpClient),
+		Storage:   storage,
+		Dedup:     media.NewDedupCache(cfg.DedupTTL),
+		Index:     media.NewStorageIndex(storage),
+		Publisher: media.NewWebhookPublisher(media.NewPublisher(cfg.Results), cfg.Webhook, httpClient, m),
+		Scanner:   media.NewScanner(cfg.Scan),
+		Metrics:   m,
+	})
+	if errors.Is(err, media.ErrShutdownTimeout) {
+		return 1
+	}
+	if err != nil {
+		slog.Error("media service failed", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// replay drains the dead-letter queue instead of starting the consumer, so
+// the two never run in the same process.
+func replay(ctx context.Context, cfg media.Config, limit int) int {
+	conn, err := media.NewRabbitConnection(cfg.Rabbit)
+	if err != nil {
+		slog.Error("rabbitmq connection failed", "error", err)
+		return 1
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		slog.Error("open channel", "error", err)
+		return 1
+	}
+	defer ch.Close()
+
+	n, err := media.ReplayDeadLetters(ctx, ch, cfg.Consumer, limit)
+	slog.Info("replayed dead letters", "count", n)
+	if err != nil {
+		slog.Error("dead-letter replay stopped", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// verifyStorage checks stored files against their checksums and fails when
+// any is missing or corrupt.
+func verifyStorage(ctx context.Context, storage media.Storage) int {
+	report, err := media.VerifyStored(ctx, storage)
+	slog.Info("verified stored files", "checked", report.Checked, "skipped", report.Skipped, "mismatched", report.Mismatched)
+	if err != nil {
+		slog.Error("verify stopped", "error", err)
+		return 1
+	}
+	if report.Mismatched > 0 {
+		return 1
+	}
+	return 0
+}
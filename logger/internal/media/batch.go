@@ -0,0 +1,141 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// How a batch job with some failed files is settled.
+const (
+	// BatchAllOrNothing retries the whole job, and eventually dead-letters
+	// it, unless every file succeeds. Files already stored are not fetched
+	// again on retry.
+	BatchAllOrNothing = "all-or-nothing"
+	// BatchBestEffort acks the job as soon as at least one file is stored
+	// and reports the rest as failed.
+	BatchBestEffort = "best-effort"
+)
+
+// loadBatchFailureMode reads BATCH_FAILURE_MODE.
+func loadBatchFailureMode() (string, error) {
+	switch mode := envString("BATCH_FAILURE_MODE", BatchAllOrNothing); mode {
+	case BatchAllOrNothing, BatchBestEffort:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid BATCH_FAILURE_MODE: %q", mode)
+	}
+}
+
+// handleBatch processes every file of a batch job and publishes one result
+// listing each file's outcome. When some files fail, batchMode decides
+// whether the job is acked with a partial result or goes through the usual
+// retry and dead-letter policy. Files are processed under jobCtx and the
+// result is published on ctx.
+func (p *mediaProcessor) handleBatch(ctx, jobCtx context.Context, d amqp.Delivery, job MediaJob, done func(string)) error {
+	result := MediaResult{JobID: job.JobID, Files: make([]FileResult, len(job.FileIDs))}
+
+	var errs []error
+	stored, allPermanent := 0, true
+	for i, fileID := range job.FileIDs {
+		file, err := p.processBatchFile(jobCtx, job, i, fileID)
+		if err != nil && timedOut(jobCtx) {
+			file.Status = StatusTimeout
+		}
+		result.Files[i] = file
+		if err != nil {
+			errs = append(errs, fmt.Errorf("file %s: %w", fileID, err))
+			allPermanent = allPermanent && isPermanent(err)
+			continue
+		}
+		stored++
+	}
+
+	switch stored {
+	case len(job.FileIDs):
+		result.Status = StatusStored
+	case 0:
+		result.Status = StatusFailed
+		if timedOut(jobCtx) {
+			result.Status = StatusTimeout
+		}
+	default:
+		result.Status = StatusPartial
+	}
+
+	if len(errs) == 0 {
+		if err := p.publisher.PublishResult(ctx, result); err != nil {
+			done("publish_failed")
+			return err
+		}
+		done("")
+		return nil
+	}
+
+	done(result.Status)
+	err := fmt.Errorf("job %s: %d of %d files failed: %w", job.JobID, len(errs), len(job.FileIDs), errors.Join(errs...))
+	result.Error = err.Error()
+	slog.WarnContext(ctx, "batch incomplete", "job_id", job.JobID, "stored", stored, "failed", len(errs), "mode", p.batchMode)
+
+	if p.batchMode == BatchBestEffort && stored > 0 {
+		return p.publisher.PublishResult(ctx, result)
+	}
+
+	if allPermanent {
+		err = permanent(err)
+	}
+	if isPermanent(err) || retryCount(d) >= p.maxRetries {
+		discardPartials(job.FileIDs...)
+		p.publish(ctx, result)
+	}
+	return err
+}
+
+// processBatchFile stores the file at index i of a batch job. Each file is
+// keyed by the job's FileUniqueID plus its position, so a retried batch
+// finds the files it already stored.
+func (p *mediaProcessor) processBatchFile(ctx context.Context, job MediaJob, i int, fileID string) (FileResult, error) {
+	key, err := p.keys.Key(job, fmt.Sprintf("%s-%d", job.FileUniqueID, i))
+	if err != nil {
+		err = permanent(err)
+		return FileResult{FileID: fileID, Status: StatusFailed, Error: err.Error()}, err
+	}
+	stored, err := p.fetchAndStore(ctx, fileID, key, job.MediaType, p.downloads.MaxBytes(job.MediaType))
+	if rejected, ok := isRejected(err); ok {
+		return FileResult{
+			FileID:      fileID,
+			Status:      StatusRejected,
+			Reason:      rejected.Reason,
+			ContentType: rejected.ContentType,
+			Signature:   rejected.Signature,
+			Error:       err.Error(),
+		}, err
+	}
+	if err != nil {
+		return FileResult{FileID: fileID, Status: StatusFailed, Error: err.Error()}, err
+	}
+
+	file := FileResult{
+		FileID:      fileID,
+		StorageURL:  stored.URL,
+		SignedURL:   p.signedURL(ctx, key),
+		SizeBytes:   stored.Size,
+		ContentType: stored.ContentType,
+		SHA256:      stored.SHA256,
+		Status:      StatusStored,
+	}
+
+	// Video thumbnails come from the job, which has no per-file field for
+	// them, so only photos get one inside a batch.
+	if job.MediaType == MediaPhoto {
+		thumbURL, err := p.storeThumbnail(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "thumbnail failed, continuing without it", "job_id", job.JobID, "file_id", fileID, "error", err)
+		}
+		file.ThumbnailURL = thumbURL
+	}
+	return file, nil
+}
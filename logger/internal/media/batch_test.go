@@ -0,0 +1,87 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+// recordingPublisher collects every result it is asked to publish.
+type recordingPublisher struct {
+	results []MediaResult
+}
+
+func (r *recordingPublisher) PublishResult(ctx context.Context, result MediaResult) error {
+	r.results = append(r.results, result)
+	return nil
+}
+
+func (r *recordingPublisher) SetChannel(ch AMQPChannel) error { return nil }
+
+func TestBatchFailureModes(t *testing.T) {
+	job, err := json.Marshal(MediaJob{
+		JobID:        "album-1",
+		ChatID:       42,
+		FileIDs:      []string{"good", "missing"},
+		FileUniqueID: "album",
+		MediaType:    MediaDocument,
+		RequestedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode          string
+		retries       int
+		wantErr       bool
+		wantPublished bool
+	}{
+		{mode: BatchBestEffort, wantPublished: true},
+		{mode: BatchAllOrNothing, wantErr: true},
+		{mode: BatchAllOrNothing, retries: 3, wantErr: true, wantPublished: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			storage, err := NewLocalStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub := &recordingPublisher{}
+			p := &mediaProcessor{
+				telegram:         fakeDownloader{"good": "%PDF-1.4\n"},
+				storage:          storage,
+				publisher:        pub,
+				metrics:          metrics.New(),
+				downloads:        TelegramConfig{MaxFileBytes: 1 << 20},
+				maxRetries:       3,
+				batchMode:        tt.mode,
+				allowedMIMETypes: defaultAllowedMIMETypes,
+			}
+
+			d := amqp.Delivery{Body: job, Headers: amqp.Table{retryCountHeader: int32(tt.retries)}}
+			err = p.handleMediaJob(context.Background(), "media.test", d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (len(pub.results) > 0) != tt.wantPublished {
+				t.Fatalf("published %d results, want published=%v", len(pub.results), tt.wantPublished)
+			}
+			if !tt.wantPublished {
+				return
+			}
+
+			got := pub.results[0]
+			if got.Status != StatusPartial || len(got.Files) != 2 {
+				t.Fatalf("result = %+v, want a partial result with 2 files", got)
+			}
+			if got.Files[0].Status != StatusStored || got.Files[1].Status != StatusFailed {
+				t.Errorf("file statuses = %s, %s", got.Files[0].Status, got.Files[1].Status)
+			}
+		})
+	}
+}
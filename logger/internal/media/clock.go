@@ -0,0 +1,16 @@
+package media
+
+import "time"
+
+// Clock is the source of time for TTL and backoff logic, so tests can
+// control it instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
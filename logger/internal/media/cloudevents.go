@@ -0,0 +1,61 @@
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Result formats selectable with RESULT_FORMAT.
+const (
+	ResultFormatNative      = "native"
+	ResultFormatCloudEvents = "cloudevents"
+)
+
+const (
+	cloudEventsContentType = "application/cloudevents+json"
+	cloudEventType         = "com.github.zxeenu.heavy-telegram-bot.media.result"
+	cloudEventSource       = "/heavy-telegram-bot/media"
+)
+
+// cloudEvent is a CloudEvents 1.0 envelope in structured JSON mode.
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	ID              string      `json:"id"`
+	Time            time.Time   `json:"time"`
+	Subject         string      `json:"subject,omitempty"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            MediaResult `json:"data"`
+}
+
+// loadResultFormat reads RESULT_FORMAT.
+func loadResultFormat() (string, error) {
+	switch format := envString("RESULT_FORMAT", ResultFormatNative); format {
+	case ResultFormatNative, ResultFormatCloudEvents:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid RESULT_FORMAT: %q", format)
+	}
+}
+
+// marshalCloudEvent wraps result in a CloudEvent published at t. The event
+// ID is the correlation ID, so every event traces back to the job request
+// that caused it, and the subject is the job ID.
+func marshalCloudEvent(result MediaResult, t time.Time) ([]byte, error) {
+	id := result.CorrelationID
+	if id == "" {
+		id = result.JobID
+	}
+	return json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		Type:            cloudEventType,
+		Source:          cloudEventSource,
+		ID:              id,
+		Time:            t.UTC(),
+		Subject:         result.JobID,
+		DataContentType: "application/json",
+		Data:            result,
+	})
+}
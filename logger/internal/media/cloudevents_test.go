@@ -0,0 +1,57 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPublishResultCloudEvents(t *testing.T) {
+	clock := newFakeClock()
+	ch := newFakeChannel()
+	p := &Publisher{cfg: ResultsConfig{RoutingKey: "media.results", Format: ResultFormatCloudEvents}, clock: clock}
+	if err := p.SetChannel(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withCorrelationID(context.Background(), "corr-1")
+	if err := p.PublishResult(ctx, MediaResult{JobID: "job-1", Status: StatusStored}); err != nil {
+		t.Fatalf("PublishResult: %v", err)
+	}
+	if len(ch.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(ch.published))
+	}
+	msg := ch.published[0].Msg
+	if msg.ContentType != cloudEventsContentType {
+		t.Errorf("content type = %q, want %q", msg.ContentType, cloudEventsContentType)
+	}
+	if !msg.Timestamp.Equal(clock.Now()) {
+		t.Errorf("message timestamp = %v, want %v", msg.Timestamp, clock.Now())
+	}
+
+	var event struct {
+		SpecVersion string      `json:"specversion"`
+		Type        string      `json:"type"`
+		Source      string      `json:"source"`
+		ID          string      `json:"id"`
+		Time        time.Time   `json:"time"`
+		Subject     string      `json:"subject"`
+		Data        MediaResult `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Body, &event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if event.SpecVersion != "1.0" || event.Type != cloudEventType || event.Source != cloudEventSource {
+		t.Errorf("envelope = %+v", event)
+	}
+	if event.ID != "corr-1" {
+		t.Errorf("id = %q, want the correlation ID", event.ID)
+	}
+	if !event.Time.Equal(clock.Now()) {
+		t.Errorf("time = %v, want %v", event.Time, clock.Now())
+	}
+	if event.Subject != "job-1" || event.Data.JobID != "job-1" || event.Data.CorrelationID != "corr-1" {
+		t.Errorf("subject %q, data %+v", event.Subject, event.Data)
+	}
+}
@@ -0,0 +1,171 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MissingEnvError is returned when a required environment variable is empty.
+type MissingEnvError struct {
+	Name string
+}
+
+func (e *MissingEnvError) Error() string {
+	return fmt.Sprintf("missing required env var %s", e.Name)
+}
+
+type envField struct {
+	name, value string
+}
+
+// requireEnv returns a MissingEnvError for every empty field, joined.
+func requireEnv(fields ...envField) error {
+	var errs []error
+	for _, f := range fields {
+		if f.value == "" {
+			errs = append(errs, &MissingEnvError{Name: f.name})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateRoutingKeys makes sure the MEDIA_ROUTING_KEY binding cannot pick
+// up results, which would come back as malformed jobs.
+func validateRoutingKeys(consumer ConsumerConfig, results ResultsConfig) error {
+	if consumer.RoutingKey == "" {
+		return nil
+	}
+	switch results.ExchangeType {
+	case amqp.ExchangeDirect:
+		if consumer.RoutingKey == results.RoutingKey {
+			return fmt.Errorf("invalid MEDIA_ROUTING_KEY: %q is also RESULTS_ROUTING_KEY", consumer.RoutingKey)
+		}
+	case amqp.ExchangeTopic:
+		if topicMatches(consumer.RoutingKey, results.RoutingKey) {
+			return fmt.Errorf("invalid MEDIA_ROUTING_KEY: %q matches RESULTS_ROUTING_KEY %q", consumer.RoutingKey, results.RoutingKey)
+		}
+	default:
+		return fmt.Errorf("invalid MEDIA_ROUTING_KEY: needs a direct or topic RESULTS_EXCHANGE_TYPE, not %q", results.ExchangeType)
+	}
+	return nil
+}
+
+// Config is the full service configuration, read once at startup.
+type Config struct {
+	Rabbit   RabbitConfig
+	Consumer ConsumerConfig
+	Telegram TelegramConfig
+	Storage  StorageConfig
+	Results  ResultsConfig
+	Scan     ScanConfig
+	HTTP     HTTPConfig
+	Webhook  WebhookConfig
+
+	ShutdownTimeout  time.Duration
+	DedupTTL         time.Duration
+	MaxJobAge        time.Duration
+	JobTimeout       time.Duration
+	PerChatLimit     int
+	PerChatWindow    time.Duration
+	MaxInflightBytes int64
+	StorageKeys      *KeyTemplate
+	DryRun           bool
+	BatchFailureMode string
+	ThumbnailMaxDim  int
+	AllowedMIMETypes []string
+	StripEXIF        bool
+	JPEGQuality      int
+	HealthPort       string
+}
+
+// LoadConfig reads every setting from the environment. Values that fail to
+// parse are all reported together; presence and cross-field checks are left
+// to Validate.
+func LoadConfig() (Config, error) {
+	var errs []error
+	collect := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	cfg := Config{
+		HealthPort: envString("HEALTH_PORT", defaultHealthPort),
+	}
+
+	var err error
+	cfg.Rabbit, err = LoadRabbitConfig()
+	collect(err)
+	cfg.Results, err = LoadResultsConfig()
+	collect(err)
+	cfg.Consumer, err = LoadConsumerConfig()
+	collect(err)
+	cfg.Telegram, err = LoadTelegramConfig()
+	collect(err)
+	cfg.Storage, err = LoadStorageConfig()
+	collect(err)
+	cfg.Scan, err = LoadScanConfig()
+	collect(err)
+	cfg.HTTP, err = LoadHTTPConfig()
+	collect(err)
+	cfg.Webhook, err = LoadWebhookConfig()
+	collect(err)
+
+	cfg.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	collect(err)
+	cfg.DedupTTL, err = envDuration("DEDUP_TTL", defaultDedupTTL)
+	collect(err)
+	cfg.MaxJobAge, err = envDuration("MAX_JOB_AGE", 0)
+	collect(err)
+	cfg.JobTimeout, err = envDuration("JOB_TIMEOUT", defaultJobTimeout)
+	collect(err)
+	cfg.PerChatLimit, err = envInt("PER_CHAT_LIMIT", 0, 0)
+	collect(err)
+	cfg.PerChatWindow, err = envDuration("PER_CHAT_WINDOW", defaultPerChatWindow)
+	collect(err)
+	cfg.MaxInflightBytes, err = envBytes("MAX_INFLIGHT_BYTES", 0)
+	collect(err)
+	cfg.ThumbnailMaxDim, err = envInt("THUMBNAIL_MAX_DIM", defaultThumbnailMaxDim, 1)
+	collect(err)
+	cfg.AllowedMIMETypes, err = loadAllowedMIMETypes()
+	collect(err)
+	cfg.StripEXIF, err = envBool("STRIP_EXIF", false)
+	collect(err)
+	cfg.JPEGQuality, err = envInt("JPEG_QUALITY", defaultJPEGQuality, 1)
+	collect(err)
+	cfg.BatchFailureMode, err = loadBatchFailureMode()
+	collect(err)
+	cfg.StorageKeys, err = loadKeyTemplate()
+	collect(err)
+	cfg.DryRun, err = envBool("DRY_RUN", false)
+	collect(err)
+
+	return cfg, errors.Join(errs...)
+}
+
+// Validate checks that every required setting is present and well formed,
+// returning all problems at once rather than stopping at the first.
+func (c Config) Validate() error {
+	errs := []error{
+		c.Rabbit.Validate(),
+		c.Telegram.Validate(),
+		c.Storage.Validate(),
+		c.Scan.Validate(),
+		c.Webhook.Validate(),
+	}
+	if port, err := strconv.Atoi(c.HealthPort); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid HEALTH_PORT: %q", c.HealthPort))
+	}
+	errs = append(errs, validateRoutingKeys(c.Consumer, c.Results))
+	if c.PerChatLimit > 0 && c.PerChatWindow <= 0 {
+		errs = append(errs, fmt.Errorf("invalid PER_CHAT_WINDOW: %s, must be positive when PER_CHAT_LIMIT is set", c.PerChatWindow))
+	}
+	if c.JPEGQuality > 100 {
+		errs = append(errs, fmt.Errorf("invalid JPEG_QUALITY: %d, must be at most 100", c.JPEGQuality))
+	}
+	return errors.Join(errs...)
+}
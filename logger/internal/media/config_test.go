@@ -0,0 +1,60 @@
+package media
+
+import (
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestValidateRoutingKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		media   string
+		results string
+		wantErr bool
+	}{
+		{name: "no binding", kind: amqp.ExchangeTopic, media: "", results: "media.results"},
+		{name: "distinct keys", kind: amqp.ExchangeTopic, media: "media.jobs", results: "media.results"},
+		{name: "same key", kind: amqp.ExchangeTopic, media: "media.results", results: "media.results", wantErr: true},
+		{name: "hash wildcard", kind: amqp.ExchangeTopic, media: "media.#", results: "media.results", wantErr: true},
+		{name: "star wildcard", kind: amqp.ExchangeTopic, media: "media.*", results: "media.results", wantErr: true},
+		{name: "bare hash", kind: amqp.ExchangeTopic, media: "#", results: "media.results", wantErr: true},
+		{name: "wildcard that misses", kind: amqp.ExchangeTopic, media: "media.jobs.*", results: "media.results"},
+		{name: "wildcard is literal on direct", kind: amqp.ExchangeDirect, media: "media.#", results: "media.results"},
+		{name: "same key on direct", kind: amqp.ExchangeDirect, media: "media.results", results: "media.results", wantErr: true},
+		{name: "fanout", kind: amqp.ExchangeFanout, media: "media.jobs", results: "media.results", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRoutingKeys(ConsumerConfig{RoutingKey: tt.media}, ResultsConfig{ExchangeType: tt.kind, RoutingKey: tt.results})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"media.results", "media.results", true},
+		{"media.*", "media.results", true},
+		{"media.*", "media.results.v2", false},
+		{"*.results", "media.results", true},
+		{"media.#", "media", true},
+		{"media.#", "media.results.v2", true},
+		{"#.results", "media.results", true},
+		{"#", "", true},
+		{"media.#.v2", "media.v2", true},
+		{"media.jobs", "media.results", false},
+		{"media.*.jobs", "media.results", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatches(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
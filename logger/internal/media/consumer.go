@@ -0,0 +1,242 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultMediaQueue    = "media.process"
+	defaultPrefetchCount = 1
+	defaultMediaDLX      = "media.dead"
+	defaultMaxRetries    = 3
+	defaultWorkerCount   = 1
+)
+
+// ConsumerConfig controls which queues the service consumes, how many jobs
+// run in parallel, and where failed jobs end up. A zero MessageTTLMillis
+// leaves messages without an expiry; a zero DepthPollInterval turns off
+// queue depth polling. A failed job's nth retry waits n*RetryDelay in a
+// delay queue, or is requeued straight away when RetryDelay is zero.
+// RoutingKey binds the queues to the results exchange, and SkipDeclare
+// leaves every exchange and queue to be provisioned outside the service.
+type ConsumerConfig struct {
+	Queues            []QueueConfig
+	RoutingKey        string
+	SkipDeclare       bool
+	Workers           int
+	DLX               string
+	MaxRetries        int
+	RetryDelay        time.Duration
+	MessageTTLMillis  int
+	DepthPollInterval time.Duration
+}
+
+// LoadConsumerConfig reads the consumer settings from the environment.
+func LoadConsumerConfig() (ConsumerConfig, error) {
+	prefetch, prefetchErr := envInt("PREFETCH_COUNT", defaultPrefetchCount, 1)
+	maxRetries, retriesErr := loadMaxRetries()
+	retryDelay, delayErr := envDuration("RETRY_DELAY", 0)
+	workers, workersErr := envInt("WORKER_COUNT", defaultWorkerCount, 1)
+	ttl, ttlErr := envInt("MEDIA_MESSAGE_TTL_MS", 0, 0)
+	pollInterval, pollErr := envDuration("QUEUE_DEPTH_POLL_INTERVAL", defaultQueueDepthPollInterval)
+	skipDeclare, skipErr := envBool("SKIP_TOPOLOGY_DECLARE", false)
+
+	// Anything below the worker count would leave workers idle.
+	prefetch = max(prefetch, workers)
+	queues, queuesErr := loadQueues(prefetch)
+
+	return ConsumerConfig{
+		Queues:            queues,
+		RoutingKey:        envString("MEDIA_ROUTING_KEY", ""),
+		SkipDeclare:       skipDeclare,
+		Workers:           workers,
+		DLX:               envString("MEDIA_DLX", defaultMediaDLX),
+		MaxRetries:        maxRetries,
+		RetryDelay:        retryDelay,
+		MessageTTLMillis:  ttl,
+		DepthPollInterval: pollInterval,
+	}, errors.Join(prefetchErr, queuesErr, retriesErr, delayErr, workersErr, ttlErr, pollErr, skipErr)
+}
+
+// loadMaxRetries reads MAX_ATTEMPTS, the total number of times a job is
+// tried, falling back to the older MAX_RETRIES, which excludes the first
+// attempt.
+func loadMaxRetries() (int, error) {
+	if os.Getenv("MAX_ATTEMPTS") == "" {
+		return envInt("MAX_RETRIES", defaultMaxRetries, 0)
+	}
+	attempts, err := envInt("MAX_ATTEMPTS", defaultMaxRetries+1, 1)
+	return attempts - 1, err
+}
+
+// StartConsumer consumes each of cfg.Queues, which DeclareTopology or the
+// broker's own provisioning must already have created, and hands deliveries
+// to a pool of cfg.Workers goroutines running handler, higher priority
+// queues first. Successful deliveries are acked; failed ones are retried up
+// to cfg.MaxRetries times and then nacked into the dead-letter queue. Each
+// delivery is recorded in tracker while a worker holds it. It blocks until
+// ctx is cancelled or the channel goes away, then waits for the workers to
+// finish what they are running.
+func StartConsumer(ctx context.Context, ch AMQPChannel, cfg ConsumerConfig, tracker *jobTracker, handler func(queue string, d amqp.Delivery) error) error {
+	jobs := newPriorityJobs(cfg.Queues)
+	stopped := make(chan error, len(cfg.Queues))
+	var tags []string
+	for _, q := range cfg.Queues {
+		tag := "media-" + strconv.Itoa(os.Getpid()) + "-" + q.Name
+		deliveries, err := consumeQueue(ch, q, tag)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, tag)
+		slog.Info("consuming", "queue", q.Name, "priority", q.Priority, "prefetch", q.Prefetch)
+
+		go func() {
+			for d := range deliveries {
+				jobs.push(q, d)
+			}
+			stopped <- fmt.Errorf("delivery channel for %s closed", q.Name)
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWorker(ctx, ch, cfg, tracker, jobs, handler)
+		}()
+	}
+	defer wg.Wait()
+	defer jobs.close()
+
+	select {
+	case <-ctx.Done():
+		// Stop new deliveries; anything prefetched but unacked is
+		// requeued by the broker once the channel closes.
+		for _, tag := range tags {
+			if err := ch.Cancel(tag, false); err != nil {
+				slog.Warn("cancel consumer", "consumer", tag, "error", err)
+			}
+		}
+		return nil
+	case err := <-stopped:
+		return err
+	}
+}
+
+// mediaQueueArgs are the arguments every media queue is declared with.
+// RabbitMQ refuses to redeclare a queue with different arguments, so
+// changing the TTL on an existing queue means deleting it first.
+func mediaQueueArgs(cfg ConsumerConfig) amqp.Table {
+	args := amqp.Table{"x-dead-letter-exchange": cfg.DLX}
+	if cfg.MessageTTLMillis > 0 {
+		args["x-message-ttl"] = int64(cfg.MessageTTLMillis)
+	}
+	return args
+}
+
+// consumeQueue starts consuming q under tag. The prefetch is set just
+// before Consume because RabbitMQ applies a non-global Qos to each consumer
+// started after it on the channel.
+func consumeQueue(ch AMQPChannel, q QueueConfig, tag string) (<-chan amqp.Delivery, error) {
+	if err := ch.Qos(q.Prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("set prefetch on %s: %w", q.Name, err)
+	}
+
+	deliveries, err := ch.Consume(q.Name, tag, false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("consume %s: %w", q.Name, err)
+	}
+	return deliveries, nil
+}
+
+// runWorker processes deliveries from jobs until it is closed and drained.
+// Deliveries still buffered after ctx is cancelled are left unacked for the
+// broker to requeue.
+func runWorker(ctx context.Context, ch AMQPChannel, cfg ConsumerConfig, tracker *jobTracker, jobs *priorityJobs, handler func(string, amqp.Delivery) error) {
+	for {
+		job, ok := jobs.pop()
+		if !ok {
+			return
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		d := job.d
+		// Pin the correlation ID on the delivery itself so the handler,
+		// the logs and any retry copy all agree on it.
+		d.CorrelationId = correlationFromDelivery(d)
+
+		id := deliveryID(d)
+		tracker.Begin(id)
+		handleDeliverySafely(ch, cfg, job.queue, d, handler)
+		tracker.Done(id)
+	}
+}
+
+// handleDeliverySafely keeps a panicking handler from taking the worker, and
+// with it the whole pool, down.
+func handleDeliverySafely(ch AMQPChannel, cfg ConsumerConfig, queue string, d amqp.Delivery, handler func(string, amqp.Delivery) error) {
+	ctx := withCorrelationID(context.Background(), d.CorrelationId)
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "handler panicked", "message_id", d.MessageId, "panic", r, "stack", string(debug.Stack()))
+			if err := d.Nack(false, false); err != nil {
+				slog.ErrorContext(ctx, "nack failed", "message_id", d.MessageId, "error", err)
+			}
+		}
+	}()
+	handleDelivery(ctx, ch, cfg, queue, d, handler)
+}
+
+// handleDelivery runs handler on d, which arrived on queue, and settles it.
+// Retries go back to the same queue, through a delay queue when
+// cfg.RetryDelay is set. ctx carries the correlation ID for logging and the
+// retry publish.
+func handleDelivery(ctx context.Context, ch AMQPChannel, cfg ConsumerConfig, queue string, d amqp.Delivery, handler func(string, amqp.Delivery) error) {
+	started := time.Now()
+	err := handler(queue, d)
+	logDelivery(ctx, d, started, err)
+
+	if err == nil {
+		if ackErr := d.Ack(false); ackErr != nil {
+			slog.ErrorContext(ctx, "ack failed", "message_id", d.MessageId, "error", ackErr)
+		}
+		return
+	}
+
+	retries := retryCount(d)
+	if retries < cfg.MaxRetries && !isPermanent(err) {
+		retryErr := republishForRetry(ctx, ch, cfg.retryRoute(queue, retries+1), d, retries+1)
+		if retryErr == nil {
+			if ackErr := d.Ack(false); ackErr != nil {
+				slog.ErrorContext(ctx, "ack failed", "message_id", d.MessageId, "error", ackErr)
+			}
+			return
+		}
+		slog.ErrorContext(ctx, "retry publish failed, dead-lettering", "message_id", d.MessageId, "error", retryErr)
+	}
+
+	if nackErr := d.Nack(false, false); nackErr != nil {
+		slog.ErrorContext(ctx, "nack failed", "message_id", d.MessageId, "error", nackErr)
+	}
+}
+
+// deliveryID identifies a delivery for tracking, falling back to its tag
+// when the publisher did not set a message ID.
+func deliveryID(d amqp.Delivery) string {
+	if d.MessageId != "" {
+		return d.MessageId
+	}
+	return "delivery-" + strconv.FormatUint(d.DeliveryTag, 10)
+}
@@ -0,0 +1,110 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestMalformedJobIsDeadLettered(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "not json", body: "not json"},
+		{name: "empty body", body: ""},
+		{name: "wrong shape", body: `["job_id"]`},
+		{name: "missing fields", body: `{"job_id":"j1","chat_id":42}`},
+		{name: "unknown media type", body: `{"job_id":"j1","chat_id":42,"file_id":"f","file_unique_id":"u","media_type":"sticker","requested_at":"2024-01-01T00:00:00Z"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newFakeChannel()
+			cfg := ConsumerConfig{Queues: []QueueConfig{{Name: "media.test", Prefetch: 1}}, Workers: 1, DLX: "media.test.dead", MaxRetries: 3}
+			processor := &mediaProcessor{metrics: metrics.New(), maxRetries: cfg.MaxRetries}
+			if err := DeclareTopology(ch.open, cfg, ResultsConfig{}); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- StartConsumer(ctx, ch, cfg, newJobTracker(), func(queue string, d amqp.Delivery) error {
+					return processor.handleMediaJob(ctx, queue, d)
+				})
+			}()
+
+			tag := ch.deliver(amqp.Publishing{MessageId: "m1", Body: []byte(tt.body)})
+			ch.waitSettled(t, tag)
+			cancel()
+			if err := <-stopped; err != nil {
+				t.Fatalf("StartConsumer: %v", err)
+			}
+
+			if got := ch.queues["media.test"]["x-dead-letter-exchange"]; got != cfg.DLX {
+				t.Errorf("queue dead-letter exchange = %v, want %q", got, cfg.DLX)
+			}
+			if len(ch.acked) != 0 {
+				t.Errorf("acked %v, want none", ch.acked)
+			}
+			if want := []fakeNack{{Tag: tag, Requeue: false}}; len(ch.nacked) != 1 || ch.nacked[0] != want[0] {
+				t.Errorf("nacked %v, want %v", ch.nacked, want)
+			}
+			if len(ch.published) != 0 {
+				t.Errorf("published %d messages, want no retry", len(ch.published))
+			}
+		})
+	}
+}
+
+func TestRetryGoesThroughDelayQueue(t *testing.T) {
+	ch := newFakeChannel()
+	cfg := ConsumerConfig{
+		Queues:     []QueueConfig{{Name: "media.test", Prefetch: 1}},
+		Workers:    1,
+		DLX:        "media.test.dead",
+		MaxRetries: 2,
+		RetryDelay: 5 * time.Second,
+	}
+	if err := DeclareTopology(ch.open, cfg, ResultsConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- StartConsumer(ctx, ch, cfg, newJobTracker(), func(queue string, d amqp.Delivery) error {
+			return errors.New("s3 unavailable")
+		})
+	}()
+
+	first := ch.deliver(amqp.Publishing{MessageId: "m1", Body: []byte("{}")})
+	ch.waitSettled(t, first)
+	last := ch.deliver(amqp.Publishing{MessageId: "m1", Body: []byte("{}"), Headers: amqp.Table{retryCountHeader: int32(2)}})
+	ch.waitSettled(t, last)
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatalf("StartConsumer: %v", err)
+	}
+
+	for attempt, wantTTL := range map[int]int64{1: 5000, 2: 10000} {
+		args := ch.queues[retryQueue("media.test", attempt)]
+		if args["x-message-ttl"] != wantTTL || args["x-dead-letter-exchange"] != "" || args["x-dead-letter-routing-key"] != "media.test" {
+			t.Errorf("retry queue %d args = %v", attempt, args)
+		}
+	}
+	if len(ch.published) != 1 {
+		t.Fatalf("published %d retries, want 1", len(ch.published))
+	}
+	if got := ch.published[0]; got.Key != "media.test.retry.1" || got.Msg.Headers[retryCountHeader] != int32(1) {
+		t.Errorf("retry went to %q with headers %v", got.Key, got.Msg.Headers)
+	}
+	if want := []fakeNack{{Tag: last, Requeue: false}}; len(ch.nacked) != 1 || ch.nacked[0] != want[0] {
+		t.Errorf("nacked %v, want the exhausted job dead-lettered", ch.nacked)
+	}
+}
@@ -0,0 +1,112 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// retryCountHeader counts how many times a job has been put back on the
+// media queue after failing, so a job on its nth attempt carries n-1.
+const retryCountHeader = "x-retry-count"
+
+// retryQueue is the delay queue a job from queue waits in before its
+// attempt-th retry.
+func retryQueue(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+// retryQueueArgs are the arguments of the delay queue for the attempt-th
+// retry of queue. It holds messages for attempt*cfg.RetryDelay and then
+// dead-letters them back onto queue through the default exchange, so a
+// failing dependency is not hammered with immediate redeliveries. As with
+// the media queue, changing the delay means deleting the existing delay
+// queues first.
+func retryQueueArgs(queue string, attempt int, cfg ConsumerConfig) amqp.Table {
+	return amqp.Table{
+		"x-message-ttl":             (time.Duration(attempt) * cfg.RetryDelay).Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queue,
+	}
+}
+
+// retryRoute is the routing key, on the default exchange, for the
+// attempt-th retry of a job from queue.
+func (cfg ConsumerConfig) retryRoute(queue string, attempt int) string {
+	if cfg.RetryDelay <= 0 {
+		return queue
+	}
+	return retryQueue(queue, attempt)
+}
+
+// deadLetterQueue is the queue bound to dlx that collects failed jobs.
+func deadLetterQueue(dlx string) string {
+	return dlx + ".queue"
+}
+
+// DeclareDeadLetter declares the dead-letter exchange and its bound queue.
+// Messages nacked without requeue on the media queue land here with their
+// x-death header intact.
+func DeclareDeadLetter(ch AMQPChannel, dlx string) error {
+	if err := ch.ExchangeDeclare(dlx, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare exchange %s: %w", dlx, err)
+	}
+
+	queue := deadLetterQueue(dlx)
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare queue %s: %w", queue, err)
+	}
+
+	if err := ch.QueueBind(queue, "", dlx, false, nil); err != nil {
+		return fmt.Errorf("bind %s to %s: %w", queue, dlx, err)
+	}
+	return nil
+}
+
+// retryCount reads the retry header set by republishForRetry.
+func retryCount(d amqp.Delivery) int {
+	switch v := d.Headers[retryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// firstDelivery reports whether d is the first time its job reaches the
+// service: not a retry, not a broker redelivery after a lost ack, and not
+// replayed from the dead-letter queue.
+func firstDelivery(d amqp.Delivery) bool {
+	_, replayed := d.Headers[replayedHeader]
+	return retryCount(d) == 0 && !d.Redelivered && !replayed
+}
+
+// republishForRetry puts a copy of d on queue, the media queue or one of its
+// delay queues, with its retry header bumped to attempt. The caller acks the
+// original once this succeeds.
+func republishForRetry(ctx context.Context, ch AMQPChannel, queue string, d amqp.Delivery, attempt int) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = int32(attempt)
+
+	return ch.PublishWithContext(ctx, "", queue, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+}
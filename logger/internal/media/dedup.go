@@ -0,0 +1,104 @@
+package media
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultDedupTTL = 10 * time.Minute
+
+// DedupEntry is what is remembered about a file that was already stored.
+// Key is the storage key it was stored under; the rest mirror MediaResult.
+type DedupEntry struct {
+	Key          string
+	URL          string
+	ThumbnailURL string
+	SizeBytes    int64
+	ContentType  string
+	SHA256       string
+}
+
+// DedupCache remembers recently stored files by Telegram FileUniqueID so a
+// re-forwarded file is answered without downloading it again. The in-memory
+// implementation is per process; a shared backend such as Redis can be
+// swapped in by satisfying this interface.
+type DedupCache interface {
+	Get(ctx context.Context, fileUniqueID string) (DedupEntry, bool, error)
+	Set(ctx context.Context, fileUniqueID string, entry DedupEntry) error
+}
+
+// memoryDedupCache is a concurrency-safe DedupCache whose entries expire
+// after ttl. Expired entries are dropped on lookup and swept on write.
+type memoryDedupCache struct {
+	ttl   time.Duration
+	clock Clock
+
+	mu        sync.Mutex
+	entries   map[string]memoryDedupItem
+	lastSweep time.Time
+}
+
+type memoryDedupItem struct {
+	entry     DedupEntry
+	expiresAt time.Time
+}
+
+func newMemoryDedupCache(ttl time.Duration) *memoryDedupCache {
+	return &memoryDedupCache{
+		ttl:     ttl,
+		clock:   realClock{},
+		entries: make(map[string]memoryDedupItem),
+	}
+}
+
+func (c *memoryDedupCache) Get(_ context.Context, fileUniqueID string) (DedupEntry, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.entries[fileUniqueID]
+	if !ok {
+		return DedupEntry{}, false, nil
+	}
+	if !c.clock.Now().Before(item.expiresAt) {
+		delete(c.entries, fileUniqueID)
+		return DedupEntry{}, false, nil
+	}
+	return item.entry, true, nil
+}
+
+func (c *memoryDedupCache) Set(_ context.Context, fileUniqueID string, entry DedupEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	c.entries[fileUniqueID] = memoryDedupItem{entry: entry, expiresAt: now.Add(c.ttl)}
+
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for id, item := range c.entries {
+			if !now.Before(item.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+	return nil
+}
+
+// noopDedupCache is used when DEDUP_TTL is zero.
+type noopDedupCache struct{}
+
+func (noopDedupCache) Get(context.Context, string) (DedupEntry, bool, error) {
+	return DedupEntry{}, false, nil
+}
+
+func (noopDedupCache) Set(context.Context, string, DedupEntry) error { return nil }
+
+// NewDedupCache returns an in-memory cache with the given TTL, or a no-op
+// cache when ttl is zero.
+func NewDedupCache(ttl time.Duration) DedupCache {
+	if ttl == 0 {
+		return noopDedupCache{}
+	}
+	return newMemoryDedupCache(ttl)
+}
@@ -0,0 +1,87 @@
+package media
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestMemoryDedupCacheExpires(t *testing.T) {
+	clock := newFakeClock()
+	c := newMemoryDedupCache(time.Minute)
+	c.clock = clock
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "file", DedupEntry{URL: "file:///x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(59 * time.Second)
+	if entry, ok, _ := c.Get(ctx, "file"); !ok || entry.URL != "file:///x" {
+		t.Fatalf("Get before TTL = %+v, %v; want a hit", entry, ok)
+	}
+
+	clock.Advance(time.Second)
+	if _, ok, _ := c.Get(ctx, "file"); ok {
+		t.Fatal("Get at TTL was a hit, want the entry evicted")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("%d entries left after eviction", len(c.entries))
+	}
+}
+
+func TestMemoryDedupCacheSweepsOnSet(t *testing.T) {
+	clock := newFakeClock()
+	c := newMemoryDedupCache(time.Minute)
+	c.clock = clock
+	ctx := context.Background()
+
+	c.Set(ctx, "old", DedupEntry{})
+	clock.Advance(2 * time.Minute)
+	c.Set(ctx, "new", DedupEntry{})
+
+	if _, ok := c.entries["old"]; ok {
+		t.Error("expired entry survived the sweep")
+	}
+	if _, ok := c.entries["new"]; !ok {
+		t.Error("fresh entry was swept")
+	}
+}
+
+func TestDedupHitSignsStoredKey(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseKeyTemplate("{{.ChatID}}/{{.FileUniqueID}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mediaProcessor{
+		telegram:         fakeDownloader{"doc": "%PDF-1.4\nshared"},
+		storage:          storage,
+		dedup:            NewDedupCache(time.Minute),
+		index:            NewStorageIndex(storage),
+		keys:             keys,
+		metrics:          metrics.New(),
+		downloads:        TelegramConfig{MaxFileBytes: 1 << 20},
+		allowedMIMETypes: defaultAllowedMIMETypes,
+		signedURLTTL:     time.Hour,
+	}
+
+	ctx := context.Background()
+	first, err := p.process(ctx, MediaJob{JobID: "job-1", ChatID: 1, FileID: "doc", FileUniqueID: "doc-1", MediaType: MediaDocument})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Forwarded to another chat, so the template renders a different key.
+	second, err := p.process(ctx, MediaJob{JobID: "job-2", ChatID: 2, FileID: "doc", FileUniqueID: "doc-1", MediaType: MediaDocument})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.SignedURL != first.SignedURL || second.SignedURL != storage.URL("1/doc-1") {
+		t.Errorf("signed URL on dedup hit = %q, want %q", second.SignedURL, storage.URL("1/doc-1"))
+	}
+}
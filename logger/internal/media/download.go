@@ -0,0 +1,116 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultDownloadMaxRetries = 3
+	downloadBackoffBase       = 500 * time.Millisecond
+	downloadBackoffMax        = 10 * time.Second
+)
+
+// downloadClock times the backoff between download attempts.
+var downloadClock Clock = realClock{}
+
+// retryableDownload calls DownloadTelegramFile with the maxBytes limit,
+// retrying up to maxRetries times on 5xx, 429 and network timeouts with
+// jittered exponential backoff. Other 4xx responses fail straight away since
+// repeating them cannot help, and so does anything once ctx is done.
+func retryableDownload(ctx context.Context, tg Downloader, fileID string, maxBytes int64, maxRetries int) (io.ReadCloser, error) {
+	var lastErr error
+	attempts := 0
+	for attempts <= maxRetries {
+		attempts++
+		body, err := tg.DownloadTelegramFile(ctx, fileID, maxBytes)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil || !isRetryableDownloadError(err) || attempts > maxRetries {
+			break
+		}
+
+		delay := downloadBackoff(attempts - 1)
+		slog.WarnContext(ctx, "download failed, retrying", "file_id", fileID, "attempt", attempts, "retry_in", delay.String(), "error", err)
+
+		select {
+		case <-downloadClock.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("download %s cancelled after %d attempts: %w", fileID, attempts, errors.Join(lastErr, ctx.Err()))
+		}
+	}
+	return nil, fmt.Errorf("download %s failed after %d attempts: %w", fileID, attempts, lastErr)
+}
+
+// limitedReader fails with ErrTooLarge as soon as more than limit bytes have
+// been read through it. Unlike io.LimitReader it does not quietly end the
+// stream at the limit, and it never buffers.
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.exceeded() {
+		return 0, ErrTooLarge
+	}
+	// Ask for at most one byte past the limit: enough to notice an
+	// oversized stream without pulling more of it.
+	if remaining := l.limit - l.n + 1; int64(len(b)) > remaining {
+		b = b[:remaining]
+	}
+	n, err := l.r.Read(b)
+	l.n += int64(n)
+	if l.exceeded() {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+func (l *limitedReader) exceeded() bool {
+	return l.n > l.limit
+}
+
+// isRetryableDownloadError reports whether err is worth another attempt.
+// It cannot tell a cancelled job from a timed-out request, since both wrap
+// context errors, so callers check their own context first.
+func isRetryableDownloadError(err error) bool {
+	var statusErr *DownloadStatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+	var apiErr *TelegramAPIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.Code)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// downloadBackoff returns a random delay in [0, base*2^attempt], capped at
+// downloadBackoffMax ("full jitter").
+func downloadBackoff(attempt int) time.Duration {
+	ceiling := downloadBackoffMax
+	if attempt < 16 {
+		ceiling = min(downloadBackoffBase<<attempt, downloadBackoffMax)
+	}
+	return rand.N(ceiling + 1)
+}
@@ -0,0 +1,180 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		limit   int64
+		wantErr error
+	}{
+		{name: "under limit", size: 10, limit: 11},
+		{name: "at limit", size: 10, limit: 10},
+		{name: "over limit", size: 11, limit: 10, wantErr: ErrTooLarge},
+		{name: "far over limit", size: 1 << 20, limit: 1024, wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := strings.NewReader(strings.Repeat("x", tt.size))
+			l := &limitedReader{r: src, limit: tt.limit}
+			_, err := io.Copy(io.Discard, l)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && l.n > tt.limit+1 {
+				t.Errorf("read %d bytes, want at most %d", l.n, tt.limit+1)
+			}
+		})
+	}
+}
+
+// fakeDownloader serves fixed bodies by file ID.
+type fakeDownloader map[string]string
+
+func (f fakeDownloader) DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	body, ok := f[fileID]
+	if !ok {
+		return nil, &DownloadStatusError{StatusCode: 404}
+	}
+	return io.NopCloser(strings.NewReader(body)), nil
+}
+
+func TestOversizedDownloadIsRejected(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mediaProcessor{
+		telegram:         fakeDownloader{"big": "%PDF-1.4\n" + strings.Repeat("x", 4096)},
+		storage:          storage,
+		metrics:          metrics.New(),
+		allowedMIMETypes: defaultAllowedMIMETypes,
+	}
+
+	ctx := context.Background()
+	_, err = p.fetchAndStore(ctx, "big", "document/big", MediaDocument, 1024)
+	rejected, ok := isRejected(err)
+	if !ok || rejected.Reason != RejectFileTooLarge {
+		t.Fatalf("err = %v, want a %s rejection", err, RejectFileTooLarge)
+	}
+	if !isPermanent(err) {
+		t.Error("rejection is not permanent")
+	}
+	if _, err := storage.Get(ctx, "document/big"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after rejection = %v, want ErrNotFound", err)
+	}
+}
+
+// scriptedDownloader fails with errs in turn, then serves the file.
+type scriptedDownloader struct {
+	errs  []error
+	calls int
+}
+
+func (d *scriptedDownloader) DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	d.calls++
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader("ok")), nil
+}
+
+// instantClock fires every timer straight away.
+type instantClock struct{ realClock }
+
+func (instantClock) After(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+// clientTimeoutError returns what an http.Client with a Timeout reports
+// once a slow server runs past it, as TelegramClient hands it back.
+func clientTimeoutError(t *testing.T) error {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	_, err := client.Get(srv.URL)
+	if err == nil {
+		t.Fatal("slow request did not time out")
+	}
+	return redactURLError(err)
+}
+
+func TestRetryableDownload(t *testing.T) {
+	timeout := clientTimeoutError(t)
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "5xx is retried", errs: []error{&DownloadStatusError{StatusCode: 502}, &TelegramAPIError{Code: 500}}, wantCalls: 3},
+		{name: "429 is retried", errs: []error{&DownloadStatusError{StatusCode: 429}}, wantCalls: 2},
+		{name: "other 4xx is not retried", errs: []error{&DownloadStatusError{StatusCode: 404}}, wantCalls: 1, wantErr: true},
+		{name: "client timeout is retried", errs: []error{timeout}, wantCalls: 2},
+		{name: "gives up after maxRetries", errs: []error{timeout, timeout, timeout, timeout}, wantCalls: 4, wantErr: true},
+	}
+	downloadClock = instantClock{}
+	defer func() { downloadClock = realClock{} }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &scriptedDownloader{errs: tt.errs}
+			body, err := retryableDownload(context.Background(), d, "file", 1<<20, 3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				body.Close()
+			}
+			if d.calls != tt.wantCalls {
+				t.Errorf("downloaded %d times, want %d", d.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryableDownloadStopsWhenCancelledDuringBackoff(t *testing.T) {
+	clock := newFakeClock()
+	downloadClock = clock
+	defer func() { downloadClock = realClock{} }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &scriptedDownloader{errs: []error{&DownloadStatusError{StatusCode: 503}}}
+	done := make(chan error, 1)
+	go func() {
+		_, err := retryableDownload(ctx, d, "file", 1<<20, 3)
+		done <- err
+	}()
+
+	clock.nextWait(t)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryableDownload kept waiting after cancel")
+	}
+	if d.calls != 1 {
+		t.Errorf("downloaded %d times after cancel, want 1", d.calls)
+	}
+}
@@ -0,0 +1,35 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// logDryRun logs what handling job would download and where it would store
+// it, without touching Telegram, storage or the results exchange.
+func (p *mediaProcessor) logDryRun(ctx context.Context, job MediaJob) {
+	if !job.isBatch() {
+		p.logDryRunFile(ctx, job, job.FileID, job.FileUniqueID)
+		return
+	}
+	for i, fileID := range job.FileIDs {
+		p.logDryRunFile(ctx, job, fileID, fmt.Sprintf("%s-%d", job.FileUniqueID, i))
+	}
+}
+
+func (p *mediaProcessor) logDryRunFile(ctx context.Context, job MediaJob, fileID, fileUniqueID string) {
+	key, err := p.keys.Key(job, fileUniqueID)
+	if err != nil {
+		slog.WarnContext(ctx, "dry run: no storage key", "job_id", job.JobID, "file_id", fileID, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "dry run: would store media",
+		"job_id", job.JobID,
+		"media_type", job.MediaType,
+		"file_id", fileID,
+		"key", key,
+		"url", p.storage.URL(key),
+		"max_bytes", p.downloads.MaxBytes(job.MediaType),
+	)
+}
@@ -0,0 +1,59 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestDryRunAcksWithoutSideEffects(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := newFakeChannel()
+	pub := &recordingPublisher{}
+	m := metrics.New()
+	cfg := ConsumerConfig{Queues: []QueueConfig{{Name: "media.test", Prefetch: 1}}, Workers: 1, DLX: "media.test.dead", MaxRetries: 3}
+	p := &mediaProcessor{
+		telegram:  fakeDownloader{},
+		storage:   storage,
+		publisher: pub,
+		metrics:   m,
+		dryRun:    true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- StartConsumer(ctx, ch, cfg, newJobTracker(), func(queue string, d amqp.Delivery) error {
+			return p.handleMediaJob(ctx, queue, d)
+		})
+	}()
+
+	valid := ch.deliver(amqp.Publishing{MessageId: "m1", Body: []byte(`{"job_id":"j1","chat_id":42,"file_id":"missing","file_unique_id":"u","media_type":"photo","requested_at":"2024-01-01T00:00:00Z"}`)})
+	ch.waitSettled(t, valid)
+	invalid := ch.deliver(amqp.Publishing{MessageId: "m2", Body: []byte("not json")})
+	ch.waitSettled(t, invalid)
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatalf("StartConsumer: %v", err)
+	}
+
+	if len(ch.acked) != 2 || len(ch.nacked) != 0 || len(ch.published) != 0 {
+		t.Errorf("acked %v, nacked %v, published %d; want both acked and nothing else", ch.acked, ch.nacked, len(ch.published))
+	}
+	if len(pub.results) != 0 {
+		t.Errorf("published %d results in dry run", len(pub.results))
+	}
+	if _, err := storage.Get(context.Background(), "photo/2024-01-01/u.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+	if got := testutil.ToFloat64(m.JobsTotal.WithLabelValues("media.test", "photo", "true")); got != 1 {
+		t.Errorf("media_jobs_total{dry_run=true} = %v, want 1", got)
+	}
+}
@@ -0,0 +1,75 @@
+package media
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envString returns the value of name, or def when it is unset or empty.
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt parses name as an integer no smaller than min, returning def when it
+// is unset.
+func envInt(name string, def, min int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < min {
+		return 0, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return n, nil
+}
+
+// envBool parses name with strconv.ParseBool, returning def when it is
+// unset.
+func envBool(name string, def bool) (bool, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return v, nil
+}
+
+// envBytes parses name as a positive byte count, returning def when it is
+// unset.
+func envBytes(name string, def int64) (int64, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return n, nil
+}
+
+// envDuration parses name as a Go duration such as "90s" or "10m". A bare
+// integer is read as seconds, matching SHUTDOWN_TIMEOUT.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	if secs, err := strconv.Atoi(raw); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return d, nil
+}
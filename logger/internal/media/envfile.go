@@ -0,0 +1,107 @@
+package media
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultEnvFile = ".env"
+
+// LoadEnvFile reads KEY=VALUE pairs from ENV_FILE (default .env) into the
+// process environment. Variables that are already set always win. A missing
+// default file is ignored; a missing file named by ENV_FILE is an error.
+func LoadEnvFile() error {
+	path, explicit := os.LookupEnv("ENV_FILE")
+	if !explicit || path == "" {
+		path, explicit = defaultEnvFile, false
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open ENV_FILE %q: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		key, value, ok, err := parseEnvLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		if !ok {
+			continue
+		}
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("%s:%d: set %s: %w", path, lineNo, key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	return nil
+}
+
+// parseEnvLine parses one line of an env file. ok is false for blank lines
+// and comments.
+func parseEnvLine(line string) (key, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false, nil
+	}
+	line = strings.TrimPrefix(line, "export ")
+
+	key, value, found := strings.Cut(line, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return "", "", false, fmt.Errorf("expected KEY=VALUE, got %q", line)
+	}
+	value = strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end < 0 {
+			return "", "", false, fmt.Errorf("unterminated quoted value for %s", key)
+		}
+		value, err = strconv.Unquote(value[:end+1])
+		if err != nil {
+			return "", "", false, fmt.Errorf("invalid quoted value for %s: %w", key, err)
+		}
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", "", false, fmt.Errorf("unterminated quoted value for %s", key)
+		}
+		value = value[1 : end+1]
+	default:
+		// An unquoted value ends at the first " #" comment.
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+	}
+	return key, value, true, nil
+}
+
+// closingQuote finds the unescaped double quote that ends value, which
+// starts with one.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
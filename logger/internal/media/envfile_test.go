@@ -0,0 +1,81 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEnvLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		key, value string
+		ok         bool
+		wantErr    bool
+	}{
+		{line: "", ok: false},
+		{line: "   ", ok: false},
+		{line: "# RABBITMQ", ok: false},
+		{line: "  # indented comment", ok: false},
+		{line: "RABBITMQ_HOST=rabbit", key: "RABBITMQ_HOST", value: "rabbit", ok: true},
+		{line: " RABBITMQ_PORT = 5672 ", key: "RABBITMQ_PORT", value: "5672", ok: true},
+		{line: "export S3_BUCKET_NAME=media", key: "S3_BUCKET_NAME", value: "media", ok: true},
+		{line: "EMPTY=", key: "EMPTY", value: "", ok: true},
+		{line: "URL=http://x/#frag", key: "URL", value: "http://x/#frag", ok: true},
+		{line: "WORKERS=4 # per queue", key: "WORKERS", value: "4", ok: true},
+		{line: `PASS="p@ss # not a comment"`, key: "PASS", value: "p@ss # not a comment", ok: true},
+		{line: `GREETING="say \"hi\"\n" # trailing`, key: "GREETING", value: "say \"hi\"\n", ok: true},
+		{line: `RAW='no \n escapes'`, key: "RAW", value: `no \n escapes`, ok: true},
+		{line: `OPEN="unterminated`, wantErr: true},
+		{line: `OPEN='unterminated`, wantErr: true},
+		{line: "NO_EQUALS", wantErr: true},
+		{line: "=value", wantErr: true},
+	}
+	for _, tt := range tests {
+		key, value, ok, err := parseEnvLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseEnvLine(%q) err = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if key != tt.key || value != tt.value || ok != tt.ok {
+			t.Errorf("parseEnvLine(%q) = %q, %q, %v; want %q, %q, %v", tt.line, key, value, ok, tt.key, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestLoadEnvFileMissingDefaultIsIgnored(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("ENV_FILE", "")
+	if err := LoadEnvFile(); err != nil {
+		t.Fatalf("LoadEnvFile without a .env = %v, want nil", err)
+	}
+}
+
+func TestLoadEnvFileMissingExplicitFileFails(t *testing.T) {
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	if err := LoadEnvFile(); err == nil {
+		t.Fatal("LoadEnvFile with a missing ENV_FILE succeeded")
+	}
+}
+
+func TestLoadEnvFileKeepsExistingVariables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "media.env")
+	if err := os.WriteFile(path, []byte("ENVFILE_TEST_SET=from-file\nexport ENVFILE_TEST_UNSET=\"from file\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENV_FILE", path)
+	t.Setenv("ENVFILE_TEST_SET", "from-env")
+	// Setenv restores the variable after the test; unset it for the load.
+	t.Setenv("ENVFILE_TEST_UNSET", "")
+	os.Unsetenv("ENVFILE_TEST_UNSET")
+
+	if err := LoadEnvFile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("ENVFILE_TEST_SET"); got != "from-env" {
+		t.Errorf("ENVFILE_TEST_SET = %q, want the environment's value", got)
+	}
+	if got := os.Getenv("ENVFILE_TEST_UNSET"); got != "from file" {
+		t.Errorf("ENVFILE_TEST_UNSET = %q, want the file's value", got)
+	}
+}
@@ -0,0 +1,60 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	defaultJPEGQuality = 90
+
+	// maxStripPixels keeps a small file that decodes into a huge image from
+	// exhausting memory while its metadata is stripped.
+	maxStripPixels = 50_000_000
+)
+
+// stripsMetadata reports whether stripImageMetadata handles contentType.
+func stripsMetadata(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// stripImageMetadata decodes a JPEG or PNG and encodes the pixels again,
+// which leaves behind every EXIF, XMP and other metadata segment. JPEGs are
+// re-encoded at quality. The EXIF orientation goes with the rest of the
+// metadata and is not applied to the pixels.
+func stripImageMetadata(r io.Reader, contentType string, quality int) ([]byte, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, permanent(fmt.Errorf("strip metadata: %w", err))
+	}
+	if cfg.Width*cfg.Height > maxStripPixels {
+		return nil, permanent(fmt.Errorf("strip metadata: %dx%d image is too large to re-encode", cfg.Width, cfg.Height))
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, permanent(fmt.Errorf("strip metadata: %w", err))
+	}
+
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		return nil, fmt.Errorf("strip metadata: unsupported content type %s", contentType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("strip metadata: encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+// jpegWithEXIF encodes a small image and splices an APP1 EXIF segment in
+// right after the SOI marker, where cameras put it.
+func jpegWithEXIF(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := range 16 {
+		for y := range 16 {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), []byte("GPS 51.5007N 0.1246W")...)
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, plain[:2]...)
+	out = append(out, segment...)
+	return append(out, plain[2:]...)
+}
+
+func TestStripEXIF(t *testing.T) {
+	withEXIF := jpegWithEXIF(t)
+	if !bytes.Contains(withEXIF, []byte("Exif\x00\x00")) {
+		t.Fatal("fixture has no EXIF segment")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(withEXIF)); err != nil {
+		t.Fatalf("fixture does not decode: %v", err)
+	}
+	const pdf = "%PDF-1.4\nExif\x00\x00 stays untouched"
+
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mediaProcessor{
+		telegram:         fakeDownloader{"photo": string(withEXIF), "doc": pdf, "jpeg-doc": string(withEXIF)},
+		storage:          storage,
+		metrics:          metrics.New(),
+		allowedMIMETypes: defaultAllowedMIMETypes,
+		stripEXIF:        true,
+		jpegQuality:      defaultJPEGQuality,
+	}
+
+	ctx := context.Background()
+	stored, err := p.fetchAndStore(ctx, "photo", "photo/exif", MediaPhoto, 1<<20)
+	if err != nil {
+		t.Fatalf("fetchAndStore: %v", err)
+	}
+	got := readStored(t, storage, "photo/exif")
+	if bytes.Contains(got, []byte("Exif")) || bytes.Contains(got, []byte("GPS")) {
+		t.Error("stored image still carries its EXIF segment")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+		t.Errorf("stored image does not decode: %v", err)
+	}
+	if stored.Size != int64(len(got)) {
+		t.Errorf("reported size %d, stored %d bytes", stored.Size, len(got))
+	}
+
+	if _, err := p.fetchAndStore(ctx, "doc", "document/doc", MediaDocument, 1<<20); err != nil {
+		t.Fatalf("fetchAndStore: %v", err)
+	}
+	if got := readStored(t, storage, "document/doc"); string(got) != pdf {
+		t.Errorf("document was modified: %q", got)
+	}
+
+	// A JPEG sent as a document is kept as sent, EXIF and all.
+	doc, err := p.fetchAndStore(ctx, "jpeg-doc", "document/jpeg", MediaDocument, 1<<20)
+	if err != nil {
+		t.Fatalf("fetchAndStore: %v", err)
+	}
+	if got := readStored(t, storage, "document/jpeg"); !bytes.Equal(got, withEXIF) {
+		t.Errorf("JPEG document was re-encoded: stored %d bytes, sent %d", len(got), len(withEXIF))
+	}
+	if doc.Size != int64(len(withEXIF)) {
+		t.Errorf("reported size %d, sent %d bytes", doc.Size, len(withEXIF))
+	}
+}
+
+func readStored(t *testing.T, s Storage, key string) []byte {
+	t.Helper()
+	obj, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	b, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
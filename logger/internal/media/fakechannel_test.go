@@ -0,0 +1,177 @@
+package media
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeChannel is an in-memory AMQPChannel. Tests feed deliveries with
+// deliver and inspect what the code under test declared, published and
+// settled.
+type fakeChannel struct {
+	deliveries chan amqp.Delivery
+
+	mu        sync.Mutex
+	nextTag   uint64
+	queues    map[string]amqp.Table
+	waiting   map[string][]amqp.Delivery
+	published []fakePublishing
+	acked     []uint64
+	nacked    []fakeNack
+	settled   chan uint64
+}
+
+type fakePublishing struct {
+	Exchange, Key string
+	Msg           amqp.Publishing
+}
+
+type fakeNack struct {
+	Tag     uint64
+	Requeue bool
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{
+		deliveries: make(chan amqp.Delivery, 16),
+		queues:     make(map[string]amqp.Table),
+		waiting:    make(map[string][]amqp.Delivery),
+		settled:    make(chan uint64, 16),
+	}
+}
+
+// deliver queues msg for the consumer and returns its delivery tag.
+func (f *fakeChannel) deliver(msg amqp.Publishing) uint64 {
+	d := f.delivery(msg)
+	f.deliveries <- d
+	return d.DeliveryTag
+}
+
+// enqueue leaves msg on queue for Get and returns its delivery tag.
+func (f *fakeChannel) enqueue(queue string, msg amqp.Publishing) uint64 {
+	d := f.delivery(msg)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waiting[queue] = append(f.waiting[queue], d)
+	return d.DeliveryTag
+}
+
+func (f *fakeChannel) delivery(msg amqp.Publishing) amqp.Delivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextTag++
+	return amqp.Delivery{
+		Acknowledger:  f,
+		DeliveryTag:   f.nextTag,
+		Headers:       msg.Headers,
+		ContentType:   msg.ContentType,
+		CorrelationId: msg.CorrelationId,
+		MessageId:     msg.MessageId,
+		Body:          msg.Body,
+	}
+}
+
+// waitSettled blocks until the delivery with tag is acked or nacked.
+func (f *fakeChannel) waitSettled(t *testing.T, tag uint64) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-f.settled:
+			if got == tag {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("delivery %d was never settled", tag)
+		}
+	}
+}
+
+// open hands out f itself as a DeclareTopology channel.
+func (f *fakeChannel) open() (topologyChannel, error) {
+	return f, nil
+}
+
+func (f *fakeChannel) Close() error {
+	return nil
+}
+
+func (f *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (f *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queues[name] = args
+	return amqp.Queue{Name: name}, nil
+}
+
+func (f *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (f *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (f *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return f.deliveries, nil
+}
+
+func (f *fakeChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	waiting := f.waiting[queue]
+	if len(waiting) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	f.waiting[queue] = waiting[1:]
+	return waiting[0], true, nil
+}
+
+func (f *fakeChannel) Cancel(consumer string, noWait bool) error {
+	return nil
+}
+
+func (f *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, fakePublishing{Exchange: exchange, Key: key, Msg: msg})
+	return nil
+}
+
+// PublishWithDeferredConfirmWithContext records msg like a channel that is
+// not in confirm mode, which returns no confirmation.
+func (f *fakeChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	return nil, f.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func (f *fakeChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (f *fakeChannel) Ack(tag uint64, multiple bool) error {
+	f.mu.Lock()
+	f.acked = append(f.acked, tag)
+	f.mu.Unlock()
+	f.settled <- tag
+	return nil
+}
+
+func (f *fakeChannel) Nack(tag uint64, multiple, requeue bool) error {
+	f.mu.Lock()
+	f.nacked = append(f.nacked, fakeNack{Tag: tag, Requeue: requeue})
+	f.mu.Unlock()
+	f.settled <- tag
+	return nil
+}
+
+// Reject lets fakeChannel serve as the deliveries' amqp.Acknowledger.
+func (f *fakeChannel) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
@@ -0,0 +1,96 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const defaultHealthPort = "8080"
+
+// healthState tracks what the readiness probe reports.
+type healthState struct {
+	broker      Broker
+	consuming   atomic.Bool
+	lastSuccess atomic.Int64 // unix nanoseconds, zero until the first job succeeds
+}
+
+func newHealthState(broker Broker) *healthState {
+	return &healthState{broker: broker}
+}
+
+func (h *healthState) setConsuming(v bool) { h.consuming.Store(v) }
+
+func (h *healthState) markSuccess() { h.lastSuccess.Store(time.Now().UnixNano()) }
+
+type readyResponse struct {
+	Ready             bool       `json:"ready"`
+	RabbitMQConnected bool       `json:"rabbitmq_connected"`
+	ConsumerRunning   bool       `json:"consumer_running"`
+	LastSuccessAt     *time.Time `json:"last_success_at"`
+}
+
+func (h *healthState) readiness() readyResponse {
+	conn := h.broker.Connection()
+	resp := readyResponse{
+		RabbitMQConnected: conn != nil && !conn.IsClosed(),
+		ConsumerRunning:   h.consuming.Load(),
+	}
+	resp.Ready = resp.RabbitMQConnected && resp.ConsumerRunning
+
+	if ns := h.lastSuccess.Load(); ns != 0 {
+		t := time.Unix(0, ns).UTC()
+		resp.LastSuccessAt = &t
+	}
+	return resp
+}
+
+func (h *healthState) handler(metricsHandler, mediaHandler, quotaHandler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metricsHandler)
+	mux.Handle("GET /media/{fileUniqueID}", mediaHandler)
+	mux.Handle("GET /debug/quotas", quotaHandler)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp := h.readiness()
+		status := http.StatusOK
+		if !resp.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, resp)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("write response", "error", err)
+	}
+}
+
+// startHealthServer serves the probes, /metrics, the /media lookup and the
+// per-chat quota usage on port in the background.
+func startHealthServer(port string, h *healthState, metricsHandler, mediaHandler, quotaHandler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              net.JoinHostPort("", port),
+		Handler:           h.handler(metricsHandler, mediaHandler, quotaHandler),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		slog.Info("health server listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("health server failed", "error", err)
+		}
+	}()
+	return srv
+}
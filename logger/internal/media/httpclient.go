@@ -0,0 +1,60 @@
+package media
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultHTTPTimeout             = 5 * time.Minute
+	defaultHTTPMaxIdleConns        = 100
+	defaultHTTPMaxIdleConnsPerHost = 32
+	defaultHTTPIdleConnTimeout     = 90 * time.Second
+)
+
+// HTTPConfig tunes the client shared by every download. Timeout bounds a
+// whole request, including reading the body.
+type HTTPConfig struct {
+	Timeout             time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+}
+
+// LoadHTTPConfig reads HTTP_TIMEOUT, HTTP_MAX_IDLE_CONNS,
+// HTTP_MAX_IDLE_CONNS_PER_HOST and HTTP_IDLE_CONN_TIMEOUT.
+func LoadHTTPConfig() (HTTPConfig, error) {
+	timeout, timeoutErr := envDuration("HTTP_TIMEOUT", defaultHTTPTimeout)
+	idle, idleErr := envInt("HTTP_MAX_IDLE_CONNS", defaultHTTPMaxIdleConns, 0)
+	perHost, perHostErr := envInt("HTTP_MAX_IDLE_CONNS_PER_HOST", defaultHTTPMaxIdleConnsPerHost, 1)
+	idleTimeout, idleTimeoutErr := envDuration("HTTP_IDLE_CONN_TIMEOUT", defaultHTTPIdleConnTimeout)
+	return HTTPConfig{
+		Timeout:             timeout,
+		MaxIdleConns:        idle,
+		MaxIdleConnsPerHost: perHost,
+		IdleConnTimeout:     idleTimeout,
+	}, errors.Join(timeoutErr, idleErr, perHostErr, idleTimeoutErr)
+}
+
+// NewHTTPClient builds a client with its own connection pool. Every
+// download goes to the same Telegram host, so the per-host idle limit is
+// what lets workers reuse connections instead of redialling; the default
+// transport keeps only two.
+func NewHTTPClient(cfg HTTPConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          cfg.MaxIdleConns,
+			MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+			IdleConnTimeout:       cfg.IdleConnTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
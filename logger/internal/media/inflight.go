@@ -0,0 +1,91 @@
+package media
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// byteBudget caps the bytes of media held or streamed across every worker
+// at once. A nil *byteBudget has no cap.
+type byteBudget struct {
+	limit int64
+	gauge prometheus.Gauge
+
+	mu   sync.Mutex
+	used int64
+	// freed is closed and replaced whenever bytes are released, waking
+	// every waiter to check again.
+	freed chan struct{}
+}
+
+// newByteBudget returns a budget of limit bytes reported on gauge, or nil
+// when limit is zero.
+func newByteBudget(limit int64, gauge prometheus.Gauge) *byteBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &byteBudget{limit: limit, gauge: gauge, freed: make(chan struct{})}
+}
+
+// acquire blocks until n bytes fit in the budget or ctx is done, and
+// returns the func that gives them back. A request for more than the whole
+// budget is cut down to it, so the file waits to have the budget to itself
+// rather than waiting forever.
+func (b *byteBudget) acquire(ctx context.Context, n int64) (release func(), err error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	n = min(n, b.limit)
+
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			b.gauge.Add(float64(n))
+			return sync.OnceFunc(func() { b.release(n) }), nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *byteBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+	b.mu.Unlock()
+	b.gauge.Sub(float64(n))
+}
+
+// sizedBody is a download body whose length was known before any of it was
+// read.
+type sizedBody struct {
+	io.ReadCloser
+	size int64
+}
+
+// expectedSize is how many bytes body should bring in, or maxBytes when the
+// downloader did not say.
+func expectedSize(body io.ReadCloser, maxBytes int64) int64 {
+	var size int64
+	switch b := body.(type) {
+	case *sizedBody:
+		size = b.size
+	case *partialFile:
+		size = b.size
+	default:
+		return maxBytes
+	}
+	return min(size, maxBytes)
+}
@@ -0,0 +1,135 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestByteBudgetBlocksUntilReleased(t *testing.T) {
+	m := metrics.New()
+	b := newByteBudget(100, m.InflightBytes)
+
+	first, err := b.acquire(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.InflightBytes); got != 60 {
+		t.Errorf("in-flight bytes = %v, want 60", got)
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		release, err := b.acquire(context.Background(), 60)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		acquired <- release
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second download started while it would exceed the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first()
+	first() // releasing twice must not free the bytes twice
+	select {
+	case second := <-acquired:
+		if got := testutil.ToFloat64(m.InflightBytes); got != 60 {
+			t.Errorf("in-flight bytes = %v, want 60", got)
+		}
+		second()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second download never started after the first released its bytes")
+	}
+	if got := testutil.ToFloat64(m.InflightBytes); got != 0 {
+		t.Errorf("in-flight bytes after both released = %v, want 0", got)
+	}
+}
+
+func TestByteBudgetHonoursContext(t *testing.T) {
+	b := newByteBudget(100, metrics.New().InflightBytes)
+	hold, err := b.acquire(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hold()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.acquire(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestByteBudgetOversizedFileRunsAlone(t *testing.T) {
+	b := newByteBudget(100, metrics.New().InflightBytes)
+	release, err := b.acquire(context.Background(), 1<<30)
+	if err != nil {
+		t.Fatalf("a file larger than the budget could not start: %v", err)
+	}
+	release()
+
+	if release, err := (*byteBudget)(nil).acquire(context.Background(), 1<<40); err != nil {
+		t.Fatalf("nil budget refused: %v", err)
+	} else {
+		release()
+	}
+}
+
+// sizedDownloader serves fixed bodies that report their size, as
+// TelegramClient does once getFile has answered.
+type sizedDownloader map[string]string
+
+func (d sizedDownloader) DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	body, ok := d[fileID]
+	if !ok {
+		return fakeDownloader{}.DownloadTelegramFile(ctx, fileID, maxBytes)
+	}
+	return &sizedBody{ReadCloser: io.NopCloser(strings.NewReader(body)), size: int64(len(body))}, nil
+}
+
+func TestFetchAndStoreReservesExpectedSize(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metrics.New()
+	p := &mediaProcessor{
+		storage:          storage,
+		metrics:          m,
+		allowedMIMETypes: defaultAllowedMIMETypes,
+		inflight:         newByteBudget(100, m.InflightBytes),
+	}
+	// Another download holds half the budget.
+	hold, err := p.inflight.acquire(context.Background(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hold()
+
+	// A small file starts even though its type's cap is over the budget.
+	p.telegram = sizedDownloader{"sticker": "%PDF-1.4\n"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := p.fetchAndStore(ctx, "sticker", "document/sticker", MediaDocument, 1<<20); err != nil {
+		t.Fatalf("fetchAndStore of a 9 byte file: %v", err)
+	}
+
+	// A file of unknown size reserves the whole cap, so it waits.
+	p.telegram = fakeDownloader{"unsized": "%PDF-1.4\n"}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.fetchAndStore(ctx, "unsized", "document/unsized", MediaDocument, 1<<20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("fetchAndStore of an unsized file = %v, want it to wait for the budget", err)
+	}
+}
@@ -0,0 +1,181 @@
+//go:build integration
+
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/rabbitmq"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+// TestIntegrationJobRoundTrip runs the service against a real RabbitMQ,
+// with Telegram and storage stubbed out, and checks that a published job
+// comes back as a result. Run it with go test -tags integration; it is
+// skipped when Docker is not available.
+func TestIntegrationJobRoundTrip(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	ctr, err := rabbitmq.Run(ctx, "rabbitmq:3.13-alpine")
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("start rabbitmq: %v", err)
+	}
+	rabbit := containerRabbitConfig(t, ctx, ctr)
+
+	consumer := ConsumerConfig{
+		Queues:     []QueueConfig{{Name: "media.it", Prefetch: 1}},
+		RoutingKey: "media.it.jobs",
+		Workers:    1,
+		DLX:        "media.it.dead",
+		MaxRetries: 1,
+	}
+	results := ResultsConfig{
+		Exchange:       "bot.it",
+		ExchangeType:   amqp.ExchangeTopic,
+		RoutingKey:     "media.it.results",
+		Confirms:       true,
+		ConfirmTimeout: 5 * time.Second,
+		Format:         ResultFormatNative,
+	}
+
+	conn, err := NewRabbitConnection(rabbit)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Declare what the test itself needs up front, so nothing it publishes
+	// or expects back is dropped while the service is still starting. The
+	// service's own declarations of the same topology must be idempotent.
+	open := func() (topologyChannel, error) { return conn.Channel() }
+	if err := DeclareTopology(open, consumer, results); err != nil {
+		t.Fatalf("DeclareTopology: %v", err)
+	}
+	resultQueue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind(resultQueue.Name, results.RoutingKey, results.Exchange, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	resultDeliveries, err := ch.Consume(resultQueue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		Rabbit:           rabbit,
+		Consumer:         consumer,
+		Results:          results,
+		Telegram:         TelegramConfig{MaxFileBytes: 1 << 20},
+		ShutdownTimeout:  5 * time.Second,
+		JobTimeout:       30 * time.Second,
+		BatchFailureMode: BatchAllOrNothing,
+		AllowedMIMETypes: defaultAllowedMIMETypes,
+		HealthPort:       freePort(t),
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- Run(runCtx, Deps{
+			Config:    cfg,
+			Broker:    NewReconnectingConnection(rabbit),
+			Telegram:  fakeDownloader{"doc": "%PDF-1.4\nintegration"},
+			Storage:   storage,
+			Dedup:     NewDedupCache(time.Minute),
+			Index:     NewStorageIndex(storage),
+			Publisher: NewPublisher(results),
+			Metrics:   metrics.New(),
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	}()
+
+	job, err := json.Marshal(MediaJob{
+		JobID:        "it-1",
+		ChatID:       42,
+		FileID:       "doc",
+		FileUniqueID: "doc-it",
+		MediaType:    MediaDocument,
+		RequestedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Through the bound exchange, the way the bot publishes.
+	err = ch.PublishWithContext(ctx, results.Exchange, consumer.RoutingKey, true, false, amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: "corr-it",
+		Body:          job,
+	})
+	if err != nil {
+		t.Fatalf("publish job: %v", err)
+	}
+
+	select {
+	case d := <-resultDeliveries:
+		var result MediaResult
+		if err := json.Unmarshal(d.Body, &result); err != nil {
+			t.Fatalf("decode result %q: %v", d.Body, err)
+		}
+		if result.JobID != "it-1" || result.Status != StatusStored || result.SHA256 == "" {
+			t.Errorf("result = %+v, want job it-1 stored with a checksum", result)
+		}
+		if result.CorrelationID != "corr-it" {
+			t.Errorf("result correlation_id = %q, want corr-it", result.CorrelationID)
+		}
+		if d.CorrelationId != "it-1" {
+			t.Errorf("delivery correlation ID = %q, want the job ID it-1", d.CorrelationId)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("no result within 30s")
+	}
+}
+
+func containerRabbitConfig(t *testing.T, ctx context.Context, ctr *rabbitmq.RabbitMQContainer) RabbitConfig {
+	t.Helper()
+	raw, err := ctr.AmqpURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass, _ := u.User.Password()
+	return RabbitConfig{Host: u.Hostname(), Port: u.Port(), User: u.User.Username(), Pass: pass, Vhost: defaultRabbitVhost}
+}
+
+// freePort finds a port for the health server that nothing listens on.
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
@@ -0,0 +1,115 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MediaType is the kind of Telegram media a job refers to.
+type MediaType string
+
+const (
+	MediaPhoto     MediaType = "photo"
+	MediaVideo     MediaType = "video"
+	MediaDocument  MediaType = "document"
+	MediaVoice     MediaType = "voice"
+	MediaAnimation MediaType = "animation"
+)
+
+// mediaTypes lists every supported MediaType.
+var mediaTypes = []MediaType{MediaPhoto, MediaVideo, MediaDocument, MediaVoice, MediaAnimation}
+
+// Valid reports whether t is one of the supported media types.
+func (t MediaType) Valid() bool {
+	return slices.Contains(mediaTypes, t)
+}
+
+// MediaJob is the message the bot publishes to ask for a Telegram file to be
+// fetched and stored. ThumbnailFileID is the file ID of the thumbnail
+// Telegram generated for a video, if the bot passed it along. An album is
+// sent as a single job listing every file in FileIDs instead of FileID; its
+// FileUniqueID then identifies the album as a whole.
+type MediaJob struct {
+	JobID           string    `json:"job_id"`
+	ChatID          int64     `json:"chat_id"`
+	FileID          string    `json:"file_id,omitempty"`
+	FileIDs         []string  `json:"file_ids,omitempty"`
+	FileUniqueID    string    `json:"file_unique_id"`
+	MediaType       MediaType `json:"media_type"`
+	ThumbnailFileID string    `json:"thumbnail_file_id,omitempty"`
+	RequestedAt     time.Time `json:"requested_at"`
+}
+
+// isBatch reports whether the job carries several files.
+func (j MediaJob) isBatch() bool {
+	return len(j.FileIDs) > 0
+}
+
+// UnmarshalMediaJob decodes and validates a media job message body.
+func UnmarshalMediaJob(body []byte) (MediaJob, error) {
+	var job MediaJob
+	if err := json.Unmarshal(body, &job); err != nil {
+		return MediaJob{}, fmt.Errorf("decode media job: %w", err)
+	}
+	if err := job.validate(); err != nil {
+		return MediaJob{}, err
+	}
+	return job, nil
+}
+
+func (j MediaJob) validate() error {
+	var missing []string
+	if j.JobID == "" {
+		missing = append(missing, "job_id")
+	}
+	if j.ChatID == 0 {
+		missing = append(missing, "chat_id")
+	}
+	if j.FileID == "" && len(j.FileIDs) == 0 {
+		missing = append(missing, "file_id or file_ids")
+	}
+	if j.FileUniqueID == "" {
+		missing = append(missing, "file_unique_id")
+	}
+	if j.MediaType == "" {
+		missing = append(missing, "media_type")
+	}
+	if j.RequestedAt.IsZero() {
+		missing = append(missing, "requested_at")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid media job %q: missing %s", j.JobID, strings.Join(missing, ", "))
+	}
+	if j.FileID != "" && len(j.FileIDs) > 0 {
+		return fmt.Errorf("invalid media job %q: file_id and file_ids are mutually exclusive", j.JobID)
+	}
+	if slices.Contains(j.FileIDs, "") {
+		return fmt.Errorf("invalid media job %q: empty entry in file_ids", j.JobID)
+	}
+	if !j.MediaType.Valid() {
+		return fmt.Errorf("invalid media job %q: unknown media_type %q", j.JobID, j.MediaType)
+	}
+	return nil
+}
+
+// permanentError marks a failure that retrying cannot fix, such as a
+// malformed message. The consumer dead-letters these straight away.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
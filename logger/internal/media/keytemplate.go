@@ -0,0 +1,90 @@
+package media
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultStorageKeyTemplate = "{{.MediaType}}/{{.Date}}/{{.FileUniqueID}}{{.Ext}}"
+
+// KeyFields is what a STORAGE_KEY_TEMPLATE can refer to. Date is the day
+// the job was requested, in UTC, so retries of a job land on the same key.
+type KeyFields struct {
+	ChatID       int64
+	MediaType    MediaType
+	FileUniqueID string
+	Date         string
+	Ext          string
+}
+
+// mediaExtensions are the file extensions keys get for each media type.
+// Documents can be anything, so they get none.
+var mediaExtensions = map[MediaType]string{
+	MediaPhoto:     ".jpg",
+	MediaVideo:     ".mp4",
+	MediaVoice:     ".ogg",
+	MediaAnimation: ".mp4",
+}
+
+// KeyTemplate renders storage keys from a text/template. A nil
+// *KeyTemplate uses the default template.
+type KeyTemplate struct {
+	tmpl *template.Template
+}
+
+var defaultKeyTemplate = &KeyTemplate{tmpl: template.Must(template.New("storage-key").Parse(defaultStorageKeyTemplate))}
+
+// ParseKeyTemplate parses text and renders it once against sample fields, so
+// a template that fails or yields an unsafe key is caught at startup rather
+// than on every job.
+func ParseKeyTemplate(text string) (*KeyTemplate, error) {
+	tmpl, err := template.New("storage-key").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_KEY_TEMPLATE: %w", err)
+	}
+	k := &KeyTemplate{tmpl: tmpl}
+	sample := KeyFields{ChatID: 1, MediaType: MediaPhoto, FileUniqueID: "sample", Date: "2006-01-02", Ext: ".jpg"}
+	if _, err := k.render(sample); err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_KEY_TEMPLATE: %w", err)
+	}
+	return k, nil
+}
+
+// loadKeyTemplate reads STORAGE_KEY_TEMPLATE.
+func loadKeyTemplate() (*KeyTemplate, error) {
+	return ParseKeyTemplate(envString("STORAGE_KEY_TEMPLATE", defaultStorageKeyTemplate))
+}
+
+// Key renders the storage key for fileUniqueID, which is job's own ID or
+// one derived from it for a file of a batch.
+func (k *KeyTemplate) Key(job MediaJob, fileUniqueID string) (string, error) {
+	if k == nil {
+		k = defaultKeyTemplate
+	}
+	return k.render(KeyFields{
+		ChatID:       job.ChatID,
+		MediaType:    job.MediaType,
+		FileUniqueID: sanitizeKeyPart(fileUniqueID),
+		Date:         job.RequestedAt.UTC().Format(time.DateOnly),
+		Ext:          mediaExtensions[job.MediaType],
+	})
+}
+
+func (k *KeyTemplate) render(fields KeyFields) (string, error) {
+	var b strings.Builder
+	if err := k.tmpl.Execute(&b, fields); err != nil {
+		return "", err
+	}
+	key := b.String()
+	if strings.Contains(key, "..") {
+		return "", fmt.Errorf("storage key %q contains ..", key)
+	}
+	return cleanKey(key)
+}
+
+// sanitizeKeyPart keeps a Telegram ID from adding path segments.
+func sanitizeKeyPart(s string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+}
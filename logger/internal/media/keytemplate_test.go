@@ -0,0 +1,58 @@
+package media
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	job := MediaJob{
+		ChatID:       42,
+		MediaType:    MediaPhoto,
+		FileUniqueID: "AQAD/x",
+		RequestedAt:  time.Date(2024, 3, 9, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*3600)),
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", template: defaultStorageKeyTemplate, want: "photo/2024-03-10/AQAD_x.jpg"},
+		{name: "per chat", template: "chats/{{.ChatID}}/{{.FileUniqueID}}", want: "chats/42/AQAD_x"},
+		{name: "unknown field", template: "{{.Nope}}", wantErr: true},
+		{name: "unparsable", template: "{{.MediaType", wantErr: true},
+		{name: "traversal", template: "../{{.FileUniqueID}}", wantErr: true},
+		{name: "absolute", template: "/{{.FileUniqueID}}", wantErr: true},
+		{name: "empty", template: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeyTemplate(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyTemplate err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := k.Key(job, job.FileUniqueID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyTemplateRejectsTraversalFromFields(t *testing.T) {
+	k, err := ParseKeyTemplate("{{.FileUniqueID}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := k.Key(MediaJob{MediaType: MediaDocument}, ".."); err == nil {
+		t.Errorf("key = %q, want an error", key)
+	}
+}
@@ -0,0 +1,199 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStorage keeps media on the local filesystem under a root directory.
+// Each file's content type lives in a JSON sidecar next to it.
+type LocalStorage struct {
+	root string
+}
+
+// localMeta is the sidecar written beside every stored file.
+type localMeta struct {
+	ContentType string `json:"content_type"`
+}
+
+func metaPath(p string) string {
+	return p + ".meta.json"
+}
+
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolve STORAGE_DIR %q: %w", dir, err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create STORAGE_DIR %q: %w", root, err)
+	}
+	return &LocalStorage{root: root}, nil
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	dst, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", fmt.Errorf("create dir for %s: %w", key, err)
+	}
+
+	meta, err := json.Marshal(localMeta{ContentType: contentType})
+	if err != nil {
+		return "", fmt.Errorf("encode metadata for %s: %w", key, err)
+	}
+	// The sidecar goes first so the file never appears without it.
+	if err := writeAtomic(metaPath(dst), bytes.NewReader(meta)); err != nil {
+		return "", fmt.Errorf("write metadata for %s: %w", key, err)
+	}
+	if err := writeAtomic(dst, r); err != nil {
+		// Leave no sidecar behind for a file that was never written.
+		if _, statErr := os.Stat(dst); isNotExist(statErr) {
+			os.Remove(metaPath(dst))
+		}
+		return "", fmt.Errorf("write %s: %w", key, err)
+	}
+
+	return s.URL(key), nil
+}
+
+// writeAtomic copies r into a temp file next to p, syncs it and renames it
+// onto p, so readers see either no file or the complete one. Each write gets
+// its own temp file, so concurrent writes to p cannot interleave. The temp
+// file is removed if anything fails along the way.
+func writeAtomic(p string, r io.Reader) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	// CreateTemp makes the file private; stored media stays world-readable.
+	if err = f.Chmod(0o644); err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (*Object, error) {
+	src, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(src)
+	if isNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", key, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat %s: %w", key, err)
+	}
+	contentType, err := readContentType(src)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read metadata for %s: %w", key, err)
+	}
+	return &Object{ReadCloser: f, ContentType: contentType, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+// readContentType reads the sidecar for the file at p. Files stored before
+// sidecars existed have none and get the default content type.
+func readContentType(p string) (string, error) {
+	raw, err := os.ReadFile(metaPath(p))
+	if isNotExist(err) {
+		return defaultContentType, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var meta localMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return "", err
+	}
+	if meta.ContentType == "" {
+		return defaultContentType, nil
+	}
+	return meta.ContentType, nil
+}
+
+func (s *LocalStorage) Verify(ctx context.Context, key, expectedSHA string) (bool, error) {
+	return verifyObject(ctx, s, key, expectedSHA)
+}
+
+// List walks the directory tree under root, leaving out sidecars and
+// unfinished writes.
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".meta.json") || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+// SignedURL returns URL(key); local files have no access control to sign
+// for.
+func (s *LocalStorage) SignedURL(key string, ttl time.Duration) (string, error) {
+	return s.URL(key), nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(s.root, filepath.FromSlash(key)))}
+	return u.String()
+}
@@ -0,0 +1,125 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingReader yields data and then fails, like a download cut off midway.
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	n, err := r.data.Read(b)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestLocalStoragePutLeavesNoPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCut := errors.New("connection reset")
+	body := &failingReader{data: strings.NewReader(strings.Repeat("x", 64<<10)), err: errCut}
+
+	ctx := context.Background()
+	if _, err := s.Put(ctx, "photo/abc", body, "image/jpeg"); !errors.Is(err, errCut) {
+		t.Fatalf("Put error = %v, want %v", err, errCut)
+	}
+
+	if _, err := s.Get(ctx, "photo/abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after failed Put = %v, want ErrNotFound", err)
+	}
+	// Neither the file, its sidecar nor a temp file is left behind.
+	dst, _ := s.path("photo/abc")
+	entries, err := os.ReadDir(filepath.Dir(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("%s exists after failed Put", e.Name())
+	}
+}
+
+func TestLocalStorageConcurrentPutsDoNotInterleave(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	first, second := strings.Repeat("a", 64<<10), strings.Repeat("b", 32<<10)
+
+	// The first Put is held halfway through its write while the second
+	// one runs start to finish.
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Put(ctx, "photo/abc", pr, "image/jpeg")
+		done <- err
+	}()
+	if _, err := pw.Write([]byte(first[:len(first)/2])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(ctx, "photo/abc", strings.NewReader(second), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pw.Write([]byte(first[len(first)/2:])); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(readStored(t, s, "photo/abc")); got != first && got != second {
+		t.Errorf("stored %d bytes mixing both writes", len(got))
+	}
+	dst, _ := s.path("photo/abc")
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o644 {
+		t.Errorf("file mode = %v, want 0644", perm)
+	}
+}
+
+func TestLocalStoragePutGet(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := s.Put(ctx, "photo/abc", strings.NewReader("hello"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Get(ctx, "photo/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+
+	got, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("body = %q, want %q", got, "hello")
+	}
+	if obj.ContentType != "image/png" {
+		t.Errorf("content type = %q, want image/png", obj.ContentType)
+	}
+}
@@ -0,0 +1,198 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// sensitiveKeys are attribute keys whose values are never written out,
+// regardless of log level.
+var sensitiveKeys = map[string]bool{
+	"pass":     true,
+	"password": true,
+	"secret":   true,
+	"token":    true,
+}
+
+const redacted = "[REDACTED]"
+
+// NewLogger builds the service logger from LOG_FORMAT, LOG_LEVEL and
+// LOG_SAMPLE_RATE.
+func NewLogger(w io.Writer) (*slog.Logger, error) {
+	level, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return nil, err
+	}
+	rate, err := parseSampleRate(os.Getenv("LOG_SAMPLE_RATE"))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT: %q", format)
+	}
+	handler = contextHandler{handler}
+	if rate < 1 {
+		handler = samplingHandler{Handler: handler, rate: rate}
+	}
+	return slog.New(handler), nil
+}
+
+type correlationKey struct{}
+
+// withCorrelationID attaches a correlation ID to ctx so every log line
+// written with that context carries it.
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// correlationID returns the ID attached by withCorrelationID, if any.
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// correlationFromDelivery returns the delivery's CorrelationId, or a fresh
+// UUID when the publisher did not set one.
+func correlationFromDelivery(d amqp.Delivery) string {
+	if d.CorrelationId != "" {
+		return d.CorrelationId
+	}
+	return uuid.NewString()
+}
+
+// contextHandler adds the correlation ID from the record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := correlationID(ctx); id != "" {
+		r.AddAttrs(slog.String("correlation_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// samplingHandler keeps only rate of the job log lines below warn level.
+// Whether a job is kept is decided by hashing its correlation ID, so each
+// job is logged in full or not at all. Lines at warn and above, and lines
+// that belong to no job, always pass.
+type samplingHandler struct {
+	slog.Handler
+	rate float64
+}
+
+func (h samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		if id := correlationID(ctx); id != "" && !sampled(id, h.rate) {
+			return nil
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return samplingHandler{h.Handler.WithAttrs(attrs), h.rate}
+}
+
+func (h samplingHandler) WithGroup(name string) slog.Handler {
+	return samplingHandler{h.Handler.WithGroup(name), h.rate}
+}
+
+// sampled maps id onto [0, 1) and reports whether it falls below rate.
+// IDs often differ only in their last few characters, so they go through
+// a hash that spreads that over every bit.
+func sampled(id string, rate float64) bool {
+	sum := sha256.Sum256([]byte(id))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 < rate
+}
+
+// parseSampleRate reads a LOG_SAMPLE_RATE between 0 and 1, defaulting to 1.
+func parseSampleRate(raw string) (float64, error) {
+	if raw == "" {
+		return 1, nil
+	}
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("invalid LOG_SAMPLE_RATE: %q", raw)
+	}
+	return rate, nil
+}
+
+func parseLogLevel(raw string) (slog.Level, error) {
+	switch strings.ToLower(raw) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid LOG_LEVEL: %q", raw)
+	}
+}
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// LogValue keeps the password out of logs when a RabbitConfig is logged.
+func (cfg RabbitConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("host", cfg.Host),
+		slog.String("port", cfg.Port),
+		slog.String("user", cfg.User),
+		slog.String("vhost", cfg.Vhost),
+		slog.Bool("tls", cfg.TLS),
+	)
+}
+
+// logDelivery writes the per-message line for a handled delivery.
+func logDelivery(ctx context.Context, d amqp.Delivery, started time.Time, err error) {
+	attrs := []any{
+		slog.String("message_id", d.MessageId),
+		slog.String("routing_key", d.RoutingKey),
+		slog.Duration("duration", time.Since(started)),
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "message failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	slog.InfoContext(ctx, "message handled", attrs...)
+}
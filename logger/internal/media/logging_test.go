@@ -0,0 +1,59 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(samplingHandler{Handler: contextHandler{slog.NewJSONHandler(&buf, nil)}, rate: 0.25})
+
+	kept := 0
+	for i := range 400 {
+		id := fmt.Sprintf("job-%d", i)
+		ctx := withCorrelationID(context.Background(), id)
+		buf.Reset()
+		logger.InfoContext(ctx, "media stored")
+		logger.InfoContext(ctx, "message handled")
+		logger.WarnContext(ctx, "thumbnail failed")
+
+		lines := strings.Count(buf.String(), "\n")
+		switch {
+		case sampled(id, 0.25) && lines == 3:
+			kept++
+		case !sampled(id, 0.25) && lines == 1:
+			if !strings.Contains(buf.String(), "thumbnail failed") {
+				t.Fatalf("job %s: warn line was dropped: %s", id, buf.String())
+			}
+		default:
+			t.Fatalf("job %s: got %d lines, want the job logged in full or only its warning", id, lines)
+		}
+	}
+	if kept < 60 || kept > 140 {
+		t.Errorf("kept %d of 400 jobs, want about 100", kept)
+	}
+
+	buf.Reset()
+	logger.Info("connected to rabbitmq")
+	if buf.Len() == 0 {
+		t.Error("line without a correlation ID was sampled away")
+	}
+}
+
+func TestParseSampleRate(t *testing.T) {
+	for raw, want := range map[string]float64{"": 1, "0.1": 0.1, "0": 0, "1": 1} {
+		if got, err := parseSampleRate(raw); err != nil || got != want {
+			t.Errorf("parseSampleRate(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"1.5", "-0.1", "half"} {
+		if _, err := parseSampleRate(raw); err == nil {
+			t.Errorf("parseSampleRate(%q) succeeded", raw)
+		}
+	}
+}
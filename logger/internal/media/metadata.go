@@ -0,0 +1,136 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MediaRecord is what the service knows about a processed file. Key and
+// SHA256 let the stored object be checked for corruption; records written
+// before they existed have neither.
+type MediaRecord struct {
+	FileUniqueID string    `json:"file_unique_id"`
+	MediaType    MediaType `json:"media_type"`
+	Key          string    `json:"key,omitempty"`
+	StorageURL   string    `json:"storage_url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	SizeBytes    int64     `json:"size_bytes"`
+	ContentType  string    `json:"content_type"`
+	SHA256       string    `json:"sha256,omitempty"`
+	ProcessedAt  time.Time `json:"processed_at"`
+}
+
+// MetadataIndex maps a Telegram FileUniqueID to the record of its stored
+// file, so callers can check for a file without knowing its media type.
+type MetadataIndex interface {
+	Get(ctx context.Context, fileUniqueID string) (MediaRecord, bool, error)
+	Put(ctx context.Context, record MediaRecord) error
+}
+
+// storageIndex keeps one small JSON document per file in the storage
+// backend itself, so the index survives restarts and is shared by every
+// replica using the same backend.
+type storageIndex struct {
+	storage Storage
+}
+
+func NewStorageIndex(s Storage) MetadataIndex {
+	return &storageIndex{storage: s}
+}
+
+func indexKey(fileUniqueID string) string {
+	return "index/" + strings.NewReplacer("/", "_", "\\", "_").Replace(fileUniqueID) + ".json"
+}
+
+func (idx *storageIndex) Get(ctx context.Context, fileUniqueID string) (MediaRecord, bool, error) {
+	record, err := readRecord(ctx, idx.storage, indexKey(fileUniqueID))
+	if errors.Is(err, ErrNotFound) {
+		return MediaRecord{}, false, nil
+	}
+	if err != nil {
+		return MediaRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+// readRecord decodes the index document stored under key.
+func readRecord(ctx context.Context, storage Storage, key string) (MediaRecord, error) {
+	obj, err := storage.Get(ctx, key)
+	if err != nil {
+		return MediaRecord{}, err
+	}
+	defer obj.Close()
+
+	var record MediaRecord
+	if err := json.NewDecoder(obj).Decode(&record); err != nil {
+		return MediaRecord{}, fmt.Errorf("decode index entry %s: %w", key, err)
+	}
+	return record, nil
+}
+
+func (idx *storageIndex) Put(ctx context.Context, record MediaRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode index entry for %s: %w", record.FileUniqueID, err)
+	}
+	_, err = idx.storage.Put(ctx, indexKey(record.FileUniqueID), bytes.NewReader(raw), "application/json")
+	return err
+}
+
+// lookupMedia finds the record for fileUniqueID in the index. Files stored
+// before the index existed are found by probing storage under each media
+// type's key prefix, using the object's modification time as the processed
+// time.
+func lookupMedia(ctx context.Context, index MetadataIndex, storage Storage, fileUniqueID string) (MediaRecord, bool, error) {
+	record, ok, err := index.Get(ctx, fileUniqueID)
+	if err != nil || ok {
+		return record, ok, err
+	}
+
+	for _, t := range mediaTypes {
+		key := storageKey(t, fileUniqueID)
+		obj, err := storage.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return MediaRecord{}, false, err
+		}
+		obj.Close()
+		return MediaRecord{
+			FileUniqueID: fileUniqueID,
+			MediaType:    t,
+			StorageURL:   storage.URL(key),
+			SizeBytes:    obj.Size,
+			ContentType:  obj.ContentType,
+			ProcessedAt:  obj.LastModified.UTC(),
+		}, true, nil
+	}
+	return MediaRecord{}, false, nil
+}
+
+// mediaHandler serves GET /media/{fileUniqueID} so the bot can skip
+// enqueueing files that are already stored.
+func mediaHandler(index MetadataIndex, storage Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("fileUniqueID")
+		record, ok, err := lookupMedia(r.Context(), index, storage, id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "media lookup failed", "file_unique_id", id, "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "lookup failed"})
+			return
+		}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, record)
+	}
+}
@@ -0,0 +1,66 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaHandler(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := NewStorageIndex(storage)
+	ctx := context.Background()
+
+	processedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := index.Put(ctx, MediaRecord{FileUniqueID: "indexed", MediaType: MediaPhoto, StorageURL: "file:///x", SizeBytes: 3, ContentType: "image/png", ProcessedAt: processedAt}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Put(ctx, storageKey(MediaVideo, "unindexed"), strings.NewReader("video"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /media/{fileUniqueID}", mediaHandler(index, storage))
+
+	tests := []struct {
+		id          string
+		wantStatus  int
+		wantType    MediaType
+		wantSize    int64
+		wantContent string
+	}{
+		{id: "indexed", wantStatus: http.StatusOK, wantType: MediaPhoto, wantSize: 3, wantContent: "image/png"},
+		{id: "unindexed", wantStatus: http.StatusOK, wantType: MediaVideo, wantSize: 5, wantContent: "video/mp4"},
+		{id: "missing", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/media/"+tt.id, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got MediaRecord
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.MediaType != tt.wantType || got.SizeBytes != tt.wantSize || got.ContentType != tt.wantContent {
+				t.Errorf("got %+v", got)
+			}
+			if got.ProcessedAt.IsZero() {
+				t.Error("processed_at is zero")
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+const defaultJobTimeout = 60 * time.Second
+
+// mediaProcessor downloads media from Telegram, hands it to storage and
+// reports the outcome on the results exchange.
+type mediaProcessor struct {
+	telegram   Downloader
+	storage    Storage
+	dedup      DedupCache
+	index      MetadataIndex
+	publisher  ResultPublisher
+	metrics    *metrics.Metrics
+	downloads  TelegramConfig
+	keys       *KeyTemplate
+	maxRetries int
+	maxJobAge  time.Duration
+	dryRun     bool
+	jobTimeout time.Duration
+	batchMode  string
+	scanner    Scanner // nil when ENABLE_SCAN is off
+	quota      *chatQuota
+	inflight   *byteBudget
+
+	signedURLTTL time.Duration // zero when results carry no signed URL
+
+	thumbnailMaxDim  int
+	allowedMIMETypes []string
+	stripEXIF        bool
+	jpegQuality      int
+}
+
+// handleMediaJob processes a single media delivery. Bodies that are not a
+// valid MediaJob are rejected as permanent failures. Jobs older than
+// maxJobAge, and jobs from a chat that is over its quota, are acked without
+// being processed. Files that are too large or whose content type is not
+// allowed are reported as rejected and acked. A failed result is only
+// published once the job will not be retried again. Downloading, scanning
+// and storing share a deadline of jobTimeout; a job that runs out of time
+// fails with StatusTimeout and is retried like any other transient failure.
+// In dry-run mode every delivery is only logged and acked.
+func (p *mediaProcessor) handleMediaJob(ctx context.Context, queue string, d amqp.Delivery) error {
+	job, err := UnmarshalMediaJob(d.Body)
+	if err != nil {
+		p.metrics.JobStarted(queue, "unknown", p.dryRun)("invalid")
+		if p.dryRun {
+			slog.WarnContext(ctx, "dry run: invalid media job, acking", "message_id", d.MessageId, "error", err)
+			return nil
+		}
+		return permanent(err)
+	}
+
+	if p.maxJobAge > 0 && time.Since(job.RequestedAt) > p.maxJobAge {
+		p.metrics.JobsExpired.WithLabelValues(queue, string(job.MediaType), strconv.FormatBool(p.dryRun)).Inc()
+		slog.InfoContext(ctx, "media job expired, dropping", "job_id", job.JobID, "requested_at", job.RequestedAt, "max_age", p.maxJobAge.String())
+		return nil
+	}
+
+	// Only first deliveries count against the quota, so retrying, replaying
+	// or redelivering a job the chat was already charged for cannot push it
+	// over.
+	if !p.dryRun && firstDelivery(d) && !p.quota.Allow(job.ChatID) {
+		p.metrics.JobsLimited.WithLabelValues(queue, string(job.MediaType), strconv.FormatBool(p.dryRun)).Inc()
+		slog.WarnContext(ctx, "chat over quota, dropping", "job_id", job.JobID, "chat_id", job.ChatID)
+		p.publish(ctx, MediaResult{JobID: job.JobID, Status: StatusRateLimited, Error: "chat exceeded its job quota"})
+		return nil
+	}
+
+	jobCtx, cancel := p.jobContext(ctx)
+	defer cancel()
+
+	done := p.metrics.JobStarted(queue, string(job.MediaType), p.dryRun)
+	if p.dryRun {
+		p.logDryRun(ctx, job)
+		done("")
+		return nil
+	}
+	if job.isBatch() {
+		return p.handleBatch(ctx, jobCtx, d, job, done)
+	}
+
+	result, err := p.process(jobCtx, job)
+	if rejected, ok := isRejected(err); ok {
+		done(StatusRejected)
+		slog.WarnContext(ctx, "media rejected", "job_id", job.JobID, "reason", rejected.Reason, "content_type", rejected.ContentType, "signature", rejected.Signature)
+		p.publish(ctx, MediaResult{
+			JobID:       job.JobID,
+			Status:      StatusRejected,
+			Reason:      rejected.Reason,
+			ContentType: rejected.ContentType,
+			Signature:   rejected.Signature,
+			Error:       err.Error(),
+		})
+		return nil
+	}
+	if err != nil {
+		status := StatusFailed
+		if timedOut(jobCtx) {
+			status = StatusTimeout
+			err = fmt.Errorf("timed out after %s: %w", p.jobTimeout, err)
+		}
+		done(status)
+		err = fmt.Errorf("job %s: %w", job.JobID, err)
+		if isPermanent(err) || retryCount(d) >= p.maxRetries {
+			discardPartials(job.FileID, job.ThumbnailFileID)
+			p.publish(ctx, MediaResult{JobID: job.JobID, Status: status, Error: err.Error()})
+		}
+		return err
+	}
+
+	if err := p.publisher.PublishResult(ctx, result); err != nil {
+		done("publish_failed")
+		return err
+	}
+	done("")
+	return nil
+}
+
+// jobContext bounds the work on one job by jobTimeout. Results are still
+// published on the parent context, so a timed-out job can report it.
+func (p *mediaProcessor) jobContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.jobTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.jobTimeout)
+}
+
+// timedOut reports whether jobCtx ran out of time, as opposed to being
+// cancelled by shutdown.
+func timedOut(jobCtx context.Context) bool {
+	return errors.Is(jobCtx.Err(), context.DeadlineExceeded)
+}
+
+// process stores the media for job, plus a thumbnail for photos and videos,
+// and returns the result to publish.
+func (p *mediaProcessor) process(ctx context.Context, job MediaJob) (MediaResult, error) {
+	key, err := p.keys.Key(job, job.FileUniqueID)
+	if err != nil {
+		return MediaResult{}, permanent(err)
+	}
+	if cached, ok := p.cachedResult(ctx, job); ok {
+		slog.InfoContext(ctx, "media recently stored, reusing result", "job_id", job.JobID, "file_unique_id", job.FileUniqueID)
+		return cached, nil
+	}
+
+	stored, err := p.fetchAndStore(ctx, job.FileID, key, job.MediaType, p.downloads.MaxBytes(job.MediaType))
+	if err != nil {
+		return MediaResult{}, err
+	}
+	slog.InfoContext(ctx, "media stored", "job_id", job.JobID, "media_type", job.MediaType, "url", stored.URL, "content_type", stored.ContentType)
+
+	result := MediaResult{
+		JobID:       job.JobID,
+		StorageURL:  stored.URL,
+		SignedURL:   p.signedURL(ctx, key),
+		SizeBytes:   stored.Size,
+		ContentType: stored.ContentType,
+		SHA256:      stored.SHA256,
+		Status:      StatusStored,
+	}
+
+	thumbURL, err := p.thumbnail(ctx, job, key)
+	if err != nil {
+		slog.WarnContext(ctx, "thumbnail failed, continuing without it", "job_id", job.JobID, "error", err)
+	}
+	result.ThumbnailURL = thumbURL
+
+	entry := DedupEntry{
+		Key:          key,
+		URL:          result.StorageURL,
+		ThumbnailURL: result.ThumbnailURL,
+		SizeBytes:    result.SizeBytes,
+		ContentType:  result.ContentType,
+		SHA256:       result.SHA256,
+	}
+	if err := p.dedup.Set(ctx, job.FileUniqueID, entry); err != nil {
+		slog.WarnContext(ctx, "dedup cache write failed", "file_unique_id", job.FileUniqueID, "error", err)
+	}
+
+	record := MediaRecord{
+		FileUniqueID: job.FileUniqueID,
+		MediaType:    job.MediaType,
+		Key:          key,
+		StorageURL:   result.StorageURL,
+		ThumbnailURL: result.ThumbnailURL,
+		SizeBytes:    result.SizeBytes,
+		ContentType:  result.ContentType,
+		SHA256:       result.SHA256,
+		ProcessedAt:  time.Now().UTC(),
+	}
+	if err := p.index.Put(ctx, record); err != nil {
+		slog.WarnContext(ctx, "metadata index write failed", "file_unique_id", job.FileUniqueID, "error", err)
+	}
+	return result, nil
+}
+
+// cachedResult builds a stored result for job from the dedup cache, signing
+// the key the file was stored under rather than one rendered for job. Cache
+// errors are logged and treated as a miss.
+func (p *mediaProcessor) cachedResult(ctx context.Context, job MediaJob) (MediaResult, bool) {
+	entry, ok, err := p.dedup.Get(ctx, job.FileUniqueID)
+	if err != nil {
+		slog.WarnContext(ctx, "dedup cache lookup failed", "file_unique_id", job.FileUniqueID, "error", err)
+		return MediaResult{}, false
+	}
+	if !ok {
+		return MediaResult{}, false
+	}
+	return MediaResult{
+		JobID:        job.JobID,
+		StorageURL:   entry.URL,
+		SignedURL:    p.signedURL(ctx, entry.Key),
+		ThumbnailURL: entry.ThumbnailURL,
+		SizeBytes:    entry.SizeBytes,
+		ContentType:  entry.ContentType,
+		SHA256:       entry.SHA256,
+		Status:       StatusStored,
+	}, true
+}
+
+// signedURL signs key when signedURLTTL is set. Signed URLs expire, so they
+// are made per result and never cached. A signing failure is logged and the
+// result goes out with only its storage URL.
+func (p *mediaProcessor) signedURL(ctx context.Context, key string) string {
+	if p.signedURLTTL == 0 {
+		return ""
+	}
+	u, err := p.storage.SignedURL(key, p.signedURLTTL)
+	if err != nil {
+		slog.WarnContext(ctx, "signing storage URL failed", "key", key, "error", err)
+		return ""
+	}
+	return u
+}
+
+func (p *mediaProcessor) publish(ctx context.Context, result MediaResult) {
+	if err := p.publisher.PublishResult(ctx, result); err != nil {
+		slog.ErrorContext(ctx, "publish result failed", "job_id", result.JobID, "error", err)
+	}
+}
+
+// storedFile describes media that is in storage. SHA256 is the hex digest
+// of the stored bytes.
+type storedFile struct {
+	URL         string
+	Size        int64
+	ContentType string
+	SHA256      string
+}
+
+// fetchAndStore downloads fileID unless its key is already in storage and
+// returns where the media lives. Downloads are sniffed before anything is
+// written, cut off once they pass maxBytes and, with a scanner set, scanned
+// before they reach storage. With stripEXIF set, JPEG and PNG photos are
+// re-encoded without their metadata after scanning; documents are stored
+// byte for byte. A type outside the allow-list, an oversized file or an
+// infected one fails with a RejectedError.
+func (p *mediaProcessor) fetchAndStore(ctx context.Context, fileID, key string, mediaType MediaType, maxBytes int64) (storedFile, error) {
+	existing, err := p.storage.Get(ctx, key)
+	if err == nil {
+		defer existing.Close()
+		slog.DebugContext(ctx, "media already stored, skipping download", "key", key)
+		h := sha256.New()
+		if _, err := io.Copy(h, existing); err != nil {
+			return storedFile{}, fmt.Errorf("hash stored %s: %w", key, err)
+		}
+		return storedFile{URL: p.storage.URL(key), Size: existing.Size, ContentType: existing.ContentType, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return storedFile{}, fmt.Errorf("check storage for %s: %w", key, err)
+	}
+
+	body, err := p.download(ctx, fileID, maxBytes)
+	if errors.Is(err, ErrTooLarge) {
+		return storedFile{}, permanent(&RejectedError{Reason: RejectFileTooLarge})
+	}
+	if err != nil {
+		return storedFile{}, err
+	}
+	defer body.Close()
+
+	// Nothing of the body has been read yet. Reserve the size Telegram
+	// reported; the limited reader below still stops a file that turns out
+	// bigger than maxBytes.
+	release, err := p.inflight.acquire(ctx, expectedSize(body, maxBytes))
+	if err != nil {
+		return storedFile{}, fmt.Errorf("wait for in-flight byte budget: %w", err)
+	}
+	defer release()
+
+	limited := &limitedReader{r: body, limit: maxBytes}
+	tooLarge := func(err error) error {
+		// Storage backends do not all wrap reader errors, so ask the
+		// reader rather than trusting errors.Is alone.
+		if limited.exceeded() || errors.Is(err, ErrTooLarge) {
+			return permanent(&RejectedError{Reason: RejectFileTooLarge})
+		}
+		return err
+	}
+
+	contentType, r, err := sniffContentType(limited)
+	if err != nil {
+		return storedFile{}, tooLarge(err)
+	}
+	if !mimeAllowed(p.allowedMIMETypes, contentType) {
+		return storedFile{}, permanent(&RejectedError{Reason: RejectContentType, ContentType: contentType})
+	}
+
+	known := int64(-1)
+	if p.scanner != nil {
+		spool, verdict, err := scanToTemp(ctx, p.scanner, r)
+		if err != nil {
+			return storedFile{}, tooLarge(err)
+		}
+		defer func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}()
+		if verdict.Infected {
+			return storedFile{}, permanent(&RejectedError{Reason: RejectInfected, ContentType: contentType, Signature: verdict.Signature})
+		}
+		r = spool
+		// The whole file has been read into the spool by now.
+		known = limited.n
+	}
+
+	size := func() int64 { return limited.n }
+	if p.stripEXIF && mediaType == MediaPhoto && stripsMetadata(contentType) {
+		clean, err := stripImageMetadata(r, contentType, p.jpegQuality)
+		if err != nil {
+			return storedFile{}, tooLarge(err)
+		}
+		r = bytes.NewReader(clean)
+		size = func() int64 { return int64(len(clean)) }
+		known = int64(len(clean))
+	}
+
+	// Hash on the way into storage so the write path reads the file once.
+	h := sha256.New()
+	var in io.Reader = io.TeeReader(r, h)
+	if known >= 0 {
+		in = sizedReader{Reader: in, size: known}
+	}
+	url, err := p.storage.Put(ctx, key, in, contentType)
+	if err != nil {
+		return storedFile{}, tooLarge(err)
+	}
+	return storedFile{URL: url, Size: size(), ContentType: contentType, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// download fetches fileID, resuming interrupted downloads when that is
+// turned on and the downloader supports ranges.
+func (p *mediaProcessor) download(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	if rd, ok := p.telegram.(RangeDownloader); ok && p.downloads.ResumableDownloads {
+		return resumableDownload(ctx, rd, fileID, maxBytes, p.downloads.DownloadMaxRetries)
+	}
+	return retryableDownload(ctx, p.telegram, fileID, maxBytes, p.downloads.DownloadMaxRetries)
+}
+
+// thumbnail stores a thumbnail for the media at key and returns its URL.
+// Photos are scaled down here; videos use the thumbnail Telegram already
+// made, when the job names one. Other types get none.
+func (p *mediaProcessor) thumbnail(ctx context.Context, job MediaJob, key string) (string, error) {
+	switch job.MediaType {
+	case MediaPhoto:
+		return p.storeThumbnail(ctx, key)
+	case MediaVideo:
+		if job.ThumbnailFileID == "" {
+			return "", nil
+		}
+		stored, err := p.fetchAndStore(ctx, job.ThumbnailFileID, thumbnailKey(key), MediaPhoto, p.downloads.MaxBytes(MediaPhoto))
+		return stored.URL, err
+	default:
+		return "", nil
+	}
+}
+
+// storeThumbnail reads the original back from storage and stores a JPEG
+// thumbnail beside it, unless one is already there.
+func (p *mediaProcessor) storeThumbnail(ctx context.Context, key string) (string, error) {
+	thumbKey := thumbnailKey(key)
+
+	found, err := exists(ctx, p.storage, thumbKey)
+	if err != nil {
+		return "", fmt.Errorf("check storage for %s: %w", thumbKey, err)
+	}
+	if found {
+		return p.storage.URL(thumbKey), nil
+	}
+
+	original, err := p.storage.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer original.Close()
+
+	thumb, err := GenerateThumbnail(original, p.thumbnailMaxDim)
+	if err != nil {
+		return "", err
+	}
+	return p.storage.Put(ctx, thumbKey, bytes.NewReader(thumb), "image/jpeg")
+}
@@ -0,0 +1,204 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultResultsRoutingKey     = "media.results"
+	defaultPublishConfirmTimeout = 5 * time.Second
+)
+
+// Result statuses reported back to the bot.
+const (
+	StatusStored   = "stored"
+	StatusFailed   = "failed"
+	StatusRejected = "rejected"
+	StatusPartial  = "partial"
+	StatusTimeout  = "timeout"
+
+	StatusRateLimited = "rate_limited"
+)
+
+// MediaResult tells the bot what happened to a MediaJob.
+type MediaResult struct {
+	JobID         string `json:"job_id"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	StorageURL    string `json:"storage_url,omitempty"`
+	SignedURL     string `json:"signed_url,omitempty"`
+	ThumbnailURL  string `json:"thumbnail_url,omitempty"`
+	SizeBytes     int64  `json:"size_bytes,omitempty"`
+	ContentType   string `json:"content_type,omitempty"`
+	SHA256        string `json:"sha256,omitempty"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+	Error         string `json:"error,omitempty"`
+
+	// Files holds the per-file outcomes of a batch job.
+	Files []FileResult `json:"files,omitempty"`
+}
+
+// FileResult is the outcome for one file of a batch job.
+type FileResult struct {
+	FileID       string `json:"file_id"`
+	StorageURL   string `json:"storage_url,omitempty"`
+	SignedURL    string `json:"signed_url,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	SizeBytes    int64  `json:"size_bytes,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+	Status       string `json:"status"`
+	Reason       string `json:"reason,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ResultsConfig says where results are published. An empty exchange is the
+// default exchange, which routes straight to the queue named by RoutingKey.
+// With Confirms set, every publish waits up to ConfirmTimeout for the
+// broker to acknowledge it. Format is ResultFormatNative or
+// ResultFormatCloudEvents.
+type ResultsConfig struct {
+	Exchange       string
+	ExchangeType   string
+	RoutingKey     string
+	Confirms       bool
+	ConfirmTimeout time.Duration
+	Format         string
+}
+
+// LoadResultsConfig reads RESULTS_EXCHANGE, RESULTS_EXCHANGE_TYPE,
+// RESULTS_ROUTING_KEY, PUBLISHER_CONFIRMS, PUBLISH_CONFIRM_TIMEOUT and
+// RESULT_FORMAT.
+func LoadResultsConfig() (ResultsConfig, error) {
+	confirms, confirmsErr := envBool("PUBLISHER_CONFIRMS", true)
+	timeout, timeoutErr := envDuration("PUBLISH_CONFIRM_TIMEOUT", defaultPublishConfirmTimeout)
+	format, formatErr := loadResultFormat()
+	kind, kindErr := loadExchangeType()
+	return ResultsConfig{
+		Exchange:       envString("RESULTS_EXCHANGE", ""),
+		ExchangeType:   kind,
+		RoutingKey:     envString("RESULTS_ROUTING_KEY", defaultResultsRoutingKey),
+		Confirms:       confirms,
+		ConfirmTimeout: timeout,
+		Format:         format,
+	}, errors.Join(confirmsErr, timeoutErr, formatErr, kindErr)
+}
+
+var (
+	// errNoChannel is returned when a result is published while disconnected.
+	errNoChannel = errors.New("publisher has no open channel")
+
+	// ErrResultNacked is returned when the broker refuses a published result.
+	ErrResultNacked = errors.New("broker nacked result")
+)
+
+// ResultPublisher reports job outcomes. SetChannel hands it the channel to
+// publish on after each reconnect.
+type ResultPublisher interface {
+	PublishResult(ctx context.Context, result MediaResult) error
+	SetChannel(ch AMQPChannel) error
+}
+
+// Publisher sends MediaResults to the results exchange. Its channel is
+// swapped out after each reconnect.
+type Publisher struct {
+	cfg   ResultsConfig
+	clock Clock
+
+	mu sync.RWMutex
+	ch AMQPChannel
+}
+
+func NewPublisher(cfg ResultsConfig) *Publisher {
+	return &Publisher{cfg: cfg, clock: realClock{}}
+}
+
+// SetChannel switches publishing to ch, putting it into confirm mode first
+// when confirms are enabled.
+func (p *Publisher) SetChannel(ch AMQPChannel) error {
+	if p.cfg.Confirms {
+		if err := ch.Confirm(false); err != nil {
+			return fmt.Errorf("enable publisher confirms: %w", err)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ch = ch
+	return nil
+}
+
+// PublishResult publishes result as persistent JSON, using the job ID as the
+// message CorrelationId. The tracing correlation ID from ctx is carried in
+// the body when the result does not already have one. In the CloudEvents
+// format the body is the result wrapped in an event envelope. With confirms
+// enabled it returns only once the broker has acked the message, and fails
+// on a nack or when no confirm arrives within the timeout.
+func (p *Publisher) PublishResult(ctx context.Context, result MediaResult) error {
+	if result.CorrelationID == "" {
+		result.CorrelationID = correlationID(ctx)
+	}
+
+	now := p.clock.Now()
+	contentType := "application/json"
+	var body []byte
+	var err error
+	if p.cfg.Format == ResultFormatCloudEvents {
+		contentType = cloudEventsContentType
+		body, err = marshalCloudEvent(result, now)
+	} else {
+		body, err = json.Marshal(result)
+	}
+	if err != nil {
+		return fmt.Errorf("marshal result for %s: %w", result.JobID, err)
+	}
+
+	p.mu.RLock()
+	ch := p.ch
+	p.mu.RUnlock()
+	if ch == nil {
+		return errNoChannel
+	}
+
+	msg := amqp.Publishing{
+		ContentType:   contentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: result.JobID,
+		Timestamp:     now,
+		Body:          body,
+	}
+
+	if !p.cfg.Confirms {
+		if err := ch.PublishWithContext(ctx, p.cfg.Exchange, p.cfg.RoutingKey, false, false, msg); err != nil {
+			return fmt.Errorf("publish result for %s: %w", result.JobID, err)
+		}
+		return nil
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.cfg.Exchange, p.cfg.RoutingKey, false, false, msg)
+	if err != nil {
+		return fmt.Errorf("publish result for %s: %w", result.JobID, err)
+	}
+	if confirm == nil {
+		return fmt.Errorf("publish result for %s: channel is not in confirm mode", result.JobID)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, p.cfg.ConfirmTimeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(waitCtx)
+	if err != nil {
+		return fmt.Errorf("confirm result for %s: %w", result.JobID, err)
+	}
+	if !acked {
+		return fmt.Errorf("publish result for %s: %w", result.JobID, ErrResultNacked)
+	}
+	return nil
+}
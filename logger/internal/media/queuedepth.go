@@ -0,0 +1,96 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultQueueDepthPollInterval = 15 * time.Second
+
+// queueInspector is the part of a channel the depth poller needs.
+type queueInspector interface {
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Close() error
+}
+
+var _ queueInspector = (*amqp.Channel)(nil)
+
+var errDisconnected = errors.New("not connected to rabbitmq")
+
+// brokerInspector opens a fresh channel on the broker's current connection.
+func brokerInspector(broker Broker) func() (queueInspector, error) {
+	return func() (queueInspector, error) {
+		conn := broker.Connection()
+		if conn == nil {
+			return nil, errDisconnected
+		}
+		return conn.Channel()
+	}
+}
+
+// startQueueDepthPoller records the ready message count of every queue in
+// depth each interval until the returned func is called, which waits for
+// the poller to stop. A zero interval disables polling.
+func startQueueDepthPoller(open func() (queueInspector, error), queues []QueueConfig, interval time.Duration, depth *prometheus.GaugeVec) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			pollQueueDepth(open, queues, depth)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
+
+// pollQueueDepth passively declares each queue to read its message count.
+// RabbitMQ closes the channel when a passive declare names a missing queue,
+// so a new channel is opened for the queues after it and the missing
+// queue's series is dropped until it shows up.
+func pollQueueDepth(open func() (queueInspector, error), queues []QueueConfig, depth *prometheus.GaugeVec) {
+	var ch queueInspector
+	defer func() {
+		if ch != nil {
+			ch.Close()
+		}
+	}()
+
+	for _, q := range queues {
+		if ch == nil {
+			var err error
+			if ch, err = open(); err != nil {
+				slog.Debug("queue depth poll skipped", "error", err)
+				return
+			}
+		}
+
+		info, err := ch.QueueDeclarePassive(q.Name, true, false, false, false, nil)
+		if err != nil {
+			slog.Debug("queue depth unavailable", "queue", q.Name, "error", err)
+			depth.DeleteLabelValues(q.Name)
+			ch.Close()
+			ch = nil
+			continue
+		}
+		depth.WithLabelValues(q.Name).Set(float64(info.Messages))
+	}
+}
@@ -0,0 +1,61 @@
+package media
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+// fakeInspector answers passive declares from counts and, like RabbitMQ,
+// is unusable after declaring a queue that does not exist.
+type fakeInspector struct {
+	counts map[string]int
+	closed bool
+}
+
+func (f *fakeInspector) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if f.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	n, ok := f.counts[name]
+	if !ok {
+		f.closed = true
+		return amqp.Queue{}, &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + name + "'"}
+	}
+	return amqp.Queue{Name: name, Messages: n}, nil
+}
+
+func (f *fakeInspector) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestPollQueueDepth(t *testing.T) {
+	m := metrics.New()
+	counts := map[string]int{"media.urgent": 3, "media.bulk": 120}
+	opened := 0
+	open := func() (queueInspector, error) {
+		opened++
+		return &fakeInspector{counts: counts}, nil
+	}
+	queues := []QueueConfig{{Name: "media.urgent"}, {Name: "media.missing"}, {Name: "media.bulk"}}
+
+	pollQueueDepth(open, queues, m.QueueDepth)
+
+	if got := testutil.ToFloat64(m.QueueDepth.WithLabelValues("media.urgent")); got != 3 {
+		t.Errorf("media.urgent depth = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(m.QueueDepth.WithLabelValues("media.bulk")); got != 120 {
+		t.Errorf("media.bulk depth = %v, want 120", got)
+	}
+	if opened != 2 {
+		t.Errorf("opened %d channels, want a fresh one after the missing queue", opened)
+	}
+	m.QueueDepth.DeleteLabelValues("media.urgent")
+	m.QueueDepth.DeleteLabelValues("media.bulk")
+	if got := testutil.CollectAndCount(m.QueueDepth); got != 0 {
+		t.Errorf("missing queue left %d series", got)
+	}
+}
@@ -0,0 +1,158 @@
+package media
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// QueueConfig is one queue the service consumes. Workers take deliveries
+// from higher Priority queues first; Prefetch caps how many unacked
+// deliveries RabbitMQ pushes for this queue.
+type QueueConfig struct {
+	Name     string
+	Priority int
+	Prefetch int
+}
+
+// loadQueues reads MEDIA_QUEUES, a comma-separated list of
+// name[:priority[:prefetch]] entries, falling back to the single
+// MEDIA_QUEUE when it is unset. Queues without their own prefetch use
+// prefetch.
+func loadQueues(prefetch int) ([]QueueConfig, error) {
+	raw := os.Getenv("MEDIA_QUEUES")
+	if raw == "" {
+		return []QueueConfig{{Name: envString("MEDIA_QUEUE", defaultMediaQueue), Prefetch: prefetch}}, nil
+	}
+
+	var queues []QueueConfig
+	for _, entry := range strings.Split(raw, ",") {
+		q, err := parseQueue(strings.TrimSpace(entry), prefetch)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(queues, func(other QueueConfig) bool { return other.Name == q.Name }) {
+			return nil, fmt.Errorf("invalid MEDIA_QUEUES: %q listed twice", q.Name)
+		}
+		queues = append(queues, q)
+	}
+	return queues, nil
+}
+
+func parseQueue(entry string, prefetch int) (QueueConfig, error) {
+	parts := strings.Split(entry, ":")
+	if parts[0] == "" || len(parts) > 3 {
+		return QueueConfig{}, fmt.Errorf("invalid MEDIA_QUEUES entry: %q", entry)
+	}
+
+	q := QueueConfig{Name: parts[0], Prefetch: prefetch}
+	if len(parts) > 1 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 0 {
+			return QueueConfig{}, fmt.Errorf("invalid priority in MEDIA_QUEUES entry: %q", entry)
+		}
+		q.Priority = n
+	}
+	if len(parts) > 2 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 1 {
+			return QueueConfig{}, fmt.Errorf("invalid prefetch in MEDIA_QUEUES entry: %q", entry)
+		}
+		q.Prefetch = n
+	}
+	return q, nil
+}
+
+// queuedDelivery is a delivery waiting for a worker, with the queue it
+// came from.
+type queuedDelivery struct {
+	queue string
+	d     amqp.Delivery
+}
+
+// priorityJobs buffers deliveries from every consumed queue and hands them
+// to workers highest priority first. Each pending delivery holds one token
+// in ready, so a worker that takes a token always finds a delivery.
+type priorityJobs struct {
+	ready chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	levels []jobLevel // highest priority first
+}
+
+type jobLevel struct {
+	priority int
+	pending  []queuedDelivery
+}
+
+// newPriorityJobs sizes the buffer to the queues' combined prefetch, which
+// bounds how many unacked deliveries the broker can push.
+func newPriorityJobs(queues []QueueConfig) *priorityJobs {
+	var capacity int
+	var priorities []int
+	for _, q := range queues {
+		capacity += q.Prefetch
+		if !slices.Contains(priorities, q.Priority) {
+			priorities = append(priorities, q.Priority)
+		}
+	}
+	slices.Sort(priorities)
+	slices.Reverse(priorities)
+
+	j := &priorityJobs{ready: make(chan struct{}, capacity)}
+	for _, p := range priorities {
+		j.levels = append(j.levels, jobLevel{priority: p})
+	}
+	return j
+}
+
+// push queues d from q. Deliveries pushed after close are dropped and left
+// unacked for the broker to requeue.
+func (j *priorityJobs) push(q QueueConfig, d amqp.Delivery) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return
+	}
+	for i := range j.levels {
+		if j.levels[i].priority == q.Priority {
+			j.levels[i].pending = append(j.levels[i].pending, queuedDelivery{queue: q.Name, d: d})
+			break
+		}
+	}
+	j.ready <- struct{}{}
+}
+
+// pop blocks until a delivery is pending and returns the oldest one of the
+// highest priority. It reports false once the buffer is closed and drained.
+func (j *priorityJobs) pop() (queuedDelivery, bool) {
+	if _, ok := <-j.ready; !ok {
+		return queuedDelivery{}, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := range j.levels {
+		if pending := j.levels[i].pending; len(pending) > 0 {
+			j.levels[i].pending = pending[1:]
+			return pending[0], true
+		}
+	}
+	// Unreachable: every token matches a pending delivery.
+	return queuedDelivery{}, false
+}
+
+// close stops further pushes; workers drain what is already buffered.
+func (j *priorityJobs) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.closed {
+		j.closed = true
+		close(j.ready)
+	}
+}
@@ -0,0 +1,66 @@
+package media
+
+import (
+	"slices"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestLoadQueues(t *testing.T) {
+	tests := []struct {
+		name    string
+		queues  string
+		want    []QueueConfig
+		wantErr bool
+	}{
+		{name: "single queue fallback", want: []QueueConfig{{Name: "media.single", Prefetch: 2}}},
+		{
+			name:   "list",
+			queues: "media.urgent:10:8, media.bulk",
+			want:   []QueueConfig{{Name: "media.urgent", Priority: 10, Prefetch: 8}, {Name: "media.bulk", Prefetch: 2}},
+		},
+		{name: "bad priority", queues: "media.urgent:high", wantErr: true},
+		{name: "zero prefetch", queues: "media.urgent:1:0", wantErr: true},
+		{name: "empty name", queues: "media.urgent,,media.bulk", wantErr: true},
+		{name: "duplicate", queues: "media.bulk,media.bulk:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MEDIA_QUEUE", "media.single")
+			t.Setenv("MEDIA_QUEUES", tt.queues)
+			got, err := loadQueues(2)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("queues = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriorityJobsDrainsHighPriorityFirst(t *testing.T) {
+	urgent := QueueConfig{Name: "media.urgent", Priority: 10, Prefetch: 2}
+	bulk := QueueConfig{Name: "media.bulk", Prefetch: 2}
+	jobs := newPriorityJobs([]QueueConfig{bulk, urgent})
+
+	jobs.push(bulk, amqp.Delivery{DeliveryTag: 1})
+	jobs.push(urgent, amqp.Delivery{DeliveryTag: 2})
+	jobs.push(bulk, amqp.Delivery{DeliveryTag: 3})
+	jobs.push(urgent, amqp.Delivery{DeliveryTag: 4})
+	jobs.close()
+	jobs.push(urgent, amqp.Delivery{DeliveryTag: 5})
+
+	var got []uint64
+	for {
+		job, ok := jobs.pop()
+		if !ok {
+			break
+		}
+		got = append(got, job.d.DeliveryTag)
+	}
+	if want := []uint64{2, 4, 1, 3}; !slices.Equal(got, want) {
+		t.Errorf("popped %v, want %v", got, want)
+	}
+}
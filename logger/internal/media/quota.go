@@ -0,0 +1,113 @@
+package media
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultPerChatWindow = time.Hour
+
+// chatQuota caps how many jobs each chat gets processed within a sliding
+// window, keeping the start time of every counted job per chat. A nil
+// *chatQuota allows everything.
+type chatQuota struct {
+	limit  int
+	window time.Duration
+	clock  Clock
+
+	mu        sync.Mutex
+	jobs      map[int64][]time.Time // oldest first
+	lastSweep time.Time
+}
+
+// newChatQuota returns a quota of limit jobs per window, or nil when limit
+// is zero.
+func newChatQuota(limit int, window time.Duration, clock Clock) *chatQuota {
+	if limit <= 0 {
+		return nil
+	}
+	return &chatQuota{limit: limit, window: window, clock: clock, jobs: make(map[int64][]time.Time)}
+}
+
+// Allow counts a job for chatID and reports whether it is within the quota.
+// Jobs over the quota are not counted, so a chat that keeps sending is let
+// through again once its earlier jobs leave the window.
+func (q *chatQuota) Allow(chatID int64) bool {
+	if q == nil {
+		return true
+	}
+	now := q.clock.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// Chats that went quiet would otherwise be kept forever.
+	if now.Sub(q.lastSweep) >= q.window {
+		q.sweep(now)
+	}
+
+	jobs := q.recent(chatID, now)
+	if len(jobs) >= q.limit {
+		q.jobs[chatID] = jobs
+		return false
+	}
+	q.jobs[chatID] = append(jobs, now)
+	return true
+}
+
+// recent drops chatID's jobs that have left the window and returns the
+// rest. The caller must hold mu.
+func (q *chatQuota) recent(chatID int64, now time.Time) []time.Time {
+	jobs := q.jobs[chatID]
+	cutoff := now.Add(-q.window)
+	i := 0
+	for i < len(jobs) && !jobs[i].After(cutoff) {
+		i++
+	}
+	return jobs[i:]
+}
+
+// sweep forgets every chat with no job left in the window. The caller must
+// hold mu.
+func (q *chatQuota) sweep(now time.Time) {
+	for chatID := range q.jobs {
+		if jobs := q.recent(chatID, now); len(jobs) > 0 {
+			q.jobs[chatID] = jobs
+		} else {
+			delete(q.jobs, chatID)
+		}
+	}
+	q.lastSweep = now
+}
+
+type quotaResponse struct {
+	Enabled bool           `json:"enabled"`
+	Limit   int            `json:"limit,omitempty"`
+	Window  string         `json:"window,omitempty"`
+	Chats   map[string]int `json:"chats"`
+}
+
+// state reports how many jobs each chat has used in the current window.
+func (q *chatQuota) state() quotaResponse {
+	if q == nil {
+		return quotaResponse{Chats: map[string]int{}}
+	}
+	now := q.clock.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep(now)
+	resp := quotaResponse{Enabled: true, Limit: q.limit, Window: q.window.String(), Chats: make(map[string]int, len(q.jobs))}
+	for chatID, jobs := range q.jobs {
+		resp.Chats[strconv.FormatInt(chatID, 10)] = len(jobs)
+	}
+	return resp
+}
+
+// quotaHandler serves GET /debug/quotas with the per-chat usage.
+func quotaHandler(q *chatQuota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, q.state())
+	}
+}
@@ -0,0 +1,142 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestChatQuotaSlidingWindow(t *testing.T) {
+	clock := newFakeClock()
+	q := newChatQuota(2, time.Minute, clock)
+
+	if !q.Allow(1) || !q.Allow(1) {
+		t.Fatal("first two jobs of chat 1 were refused")
+	}
+	if q.Allow(1) {
+		t.Fatal("third job of chat 1 within the window was allowed")
+	}
+	if !q.Allow(2) {
+		t.Fatal("chat 2 was limited by chat 1's jobs")
+	}
+
+	clock.Advance(30 * time.Second)
+	if q.Allow(1) {
+		t.Fatal("chat 1 was allowed before its jobs left the window")
+	}
+	clock.Advance(31 * time.Second)
+	if !q.Allow(1) {
+		t.Fatal("chat 1 was still refused after its jobs left the window")
+	}
+
+	state := q.state()
+	if state.Chats["1"] != 1 || state.Chats["2"] != 0 || len(state.Chats) != 1 {
+		t.Errorf("state = %+v, want only chat 1 with one job", state)
+	}
+}
+
+func TestChatQuotaConcurrent(t *testing.T) {
+	q := newChatQuota(50, time.Hour, newFakeClock())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for range 200 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if q.Allow(7) {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 50 {
+		t.Errorf("allowed %d jobs, want 50", allowed)
+	}
+}
+
+func TestRateLimitedJobIsAckedAndReported(t *testing.T) {
+	pub := &recordingPublisher{}
+	m := metrics.New()
+	p := &mediaProcessor{
+		telegram:  fakeDownloader{},
+		publisher: pub,
+		metrics:   m,
+		quota:     newChatQuota(1, time.Hour, newFakeClock()),
+	}
+	body := []byte(`{"job_id":"j1","chat_id":42,"file_id":"missing","file_unique_id":"u","media_type":"photo","requested_at":"2024-01-01T00:00:00Z"}`)
+
+	// The chat's one job is used up, so the next is refused without a
+	// download being attempted.
+	p.quota.Allow(42)
+	if err := p.handleMediaJob(context.Background(), "media.test", amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("handleMediaJob: %v", err)
+	}
+
+	if len(pub.results) != 1 || pub.results[0].Status != StatusRateLimited {
+		t.Fatalf("results = %+v, want one rate_limited result", pub.results)
+	}
+	if got := testutil.ToFloat64(m.JobsLimited.WithLabelValues("media.test", "photo", "false")); got != 1 {
+		t.Errorf("rate limited jobs = %v, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	quotaHandler(p.quota).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/quotas", nil))
+	var state quotaResponse
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Enabled || state.Limit != 1 || state.Chats["42"] != 1 {
+		t.Errorf("quota state = %+v", state)
+	}
+}
+
+func TestRepeatDeliveriesSkipQuota(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &recordingPublisher{}
+	p := &mediaProcessor{
+		telegram:         fakeDownloader{"doc": "%PDF-1.4\n"},
+		storage:          storage,
+		dedup:            NewDedupCache(0),
+		index:            NewStorageIndex(storage),
+		publisher:        pub,
+		metrics:          metrics.New(),
+		downloads:        TelegramConfig{MaxFileBytes: 1 << 20},
+		allowedMIMETypes: defaultAllowedMIMETypes,
+		quota:            newChatQuota(1, time.Hour, newFakeClock()),
+	}
+	body := []byte(`{"job_id":"j1","chat_id":42,"file_id":"doc","file_unique_id":"u","media_type":"document","requested_at":"2024-01-01T00:00:00Z"}`)
+
+	// The first delivery used the chat's one job; every later delivery of
+	// the same job must still run.
+	p.quota.Allow(42)
+	for name, d := range map[string]amqp.Delivery{
+		"retried":     {Body: body, Headers: amqp.Table{retryCountHeader: int32(1)}},
+		"redelivered": {Body: body, Redelivered: true},
+		"replayed":    {Body: body, Headers: amqp.Table{replayedHeader: true}},
+	} {
+		pub.results = nil
+		if err := p.handleMediaJob(context.Background(), "media.test", d); err != nil {
+			t.Fatalf("%s: handleMediaJob: %v", name, err)
+		}
+		if len(pub.results) != 1 || pub.results[0].Status != StatusStored {
+			t.Fatalf("%s: results = %+v, want the job stored", name, pub.results)
+		}
+	}
+	if got := p.quota.state().Chats["42"]; got != 1 {
+		t.Errorf("chat 42 charged %d jobs, want 1", got)
+	}
+}
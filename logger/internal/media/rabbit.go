@@ -0,0 +1,155 @@
+package media
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultRabbitVhost = "/"
+
+// RabbitConfig holds everything needed to dial RabbitMQ. With TLS set the
+// connection uses amqps://, trusting CACert in addition to the system roots
+// and presenting ClientCert/ClientKey when both are given. The cert fields
+// are file paths and all optional.
+type RabbitConfig struct {
+	Host  string
+	Port  string
+	User  string
+	Pass  string
+	Vhost string
+
+	TLS        bool
+	CACert     string
+	ClientCert string
+	ClientKey  string
+}
+
+// LoadRabbitConfig reads the RabbitMQ settings from the environment. The
+// host is RABBITMQ_URL, which docker-compose sets, or else RABBITMQ_HOST as
+// written in .env files shared with the other services.
+func LoadRabbitConfig() (RabbitConfig, error) {
+	useTLS, err := envBool("RABBITMQ_TLS", false)
+	host := os.Getenv("RABBITMQ_URL")
+	if host == "" {
+		host = os.Getenv("RABBITMQ_HOST")
+	}
+	return RabbitConfig{
+		Host:       host,
+		Port:       os.Getenv("RABBITMQ_PORT"),
+		User:       os.Getenv("RABBITMQ_USER"),
+		Pass:       os.Getenv("RABBITMQ_PASS"),
+		Vhost:      envString("RABBITMQ_VHOST", defaultRabbitVhost),
+		TLS:        useTLS,
+		CACert:     os.Getenv("RABBITMQ_CA_CERT"),
+		ClientCert: os.Getenv("RABBITMQ_CLIENT_CERT"),
+		ClientKey:  os.Getenv("RABBITMQ_CLIENT_KEY"),
+	}, err
+}
+
+// Validate reports every missing or malformed RabbitMQ setting.
+func (cfg RabbitConfig) Validate() error {
+	err := requireEnv(
+		envField{"RABBITMQ_URL", cfg.Host},
+		envField{"RABBITMQ_PORT", cfg.Port},
+		envField{"RABBITMQ_USER", cfg.User},
+		envField{"RABBITMQ_PASS", cfg.Pass},
+	)
+	if cfg.Port != "" {
+		if _, portErr := strconv.Atoi(cfg.Port); portErr != nil {
+			err = errors.Join(err, fmt.Errorf("invalid RABBITMQ_PORT: %q", cfg.Port))
+		}
+	}
+	if cfg.TLS {
+		if _, tlsErr := cfg.TLSConfig(); tlsErr != nil {
+			err = errors.Join(err, tlsErr)
+		}
+	}
+	return err
+}
+
+// URI builds the amqp:// or amqps:// dial string, validating every field on
+// the way.
+func (cfg RabbitConfig) URI() (string, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(cfg.User, cfg.Pass),
+		Host:   net.JoinHostPort(cfg.Host, cfg.Port),
+		Path:   "/",
+	}
+	if cfg.TLS {
+		u.Scheme = "amqps"
+	}
+	// The default vhost is the bare "/"; any other name follows the slash.
+	if cfg.Vhost != "" && cfg.Vhost != defaultRabbitVhost {
+		u.Path = "/" + cfg.Vhost
+	}
+	return u.String(), nil
+}
+
+// TLSConfig loads the certificates named by cfg. Each failure names the
+// setting at fault.
+func (cfg RabbitConfig) TLSConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{ServerName: cfg.Host, MinVersion: tls.VersionTLS12}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RABBITMQ_CA_CERT: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid RABBITMQ_CA_CERT: no PEM certificates in %q", cfg.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	switch {
+	case cfg.ClientCert != "" && cfg.ClientKey != "":
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RABBITMQ_CLIENT_CERT/RABBITMQ_CLIENT_KEY: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	case cfg.ClientCert != "" || cfg.ClientKey != "":
+		return nil, errors.New("RABBITMQ_CLIENT_CERT and RABBITMQ_CLIENT_KEY must be set together")
+	}
+	return tlsCfg, nil
+}
+
+// NewRabbitConnection dials RabbitMQ and returns the live connection.
+func NewRabbitConnection(cfg RabbitConfig) (*amqp.Connection, error) {
+	uri, err := cfg.URI()
+	if err != nil {
+		return nil, err
+	}
+
+	var conn *amqp.Connection
+	if cfg.TLS {
+		tlsCfg, tlsErr := cfg.TLSConfig()
+		if tlsErr != nil {
+			return nil, tlsErr
+		}
+		conn, err = amqp.DialTLS(uri, tlsCfg)
+	} else {
+		conn, err = amqp.Dial(uri)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial rabbitmq at %s: %w", net.JoinHostPort(cfg.Host, cfg.Port), err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,207 @@
+package media
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	reconnectBaseDelay = 1 * time.Second
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+// ErrConnectionClosed is returned once a ReconnectingConnection has been closed.
+var ErrConnectionClosed = errors.New("rabbitmq connection closed")
+
+// Broker is the RabbitMQ connection the service runs on.
+// ReconnectingConnection is the production implementation.
+type Broker interface {
+	// OnReconnect registers fn to run after every successful connect.
+	OnReconnect(fn func(*amqp.Connection) error)
+	// Start blocks until the first connection is up.
+	Start() error
+	// Connection returns the current connection, or nil while disconnected.
+	Connection() *amqp.Connection
+	Close() error
+}
+
+// ReconnectingConnection keeps a RabbitMQ connection alive, redialing with
+// exponential backoff whenever the broker drops it.
+type ReconnectingConnection struct {
+	cfg       RabbitConfig
+	dial      func(RabbitConfig) (*amqp.Connection, error)
+	closeConn func(*amqp.Connection) error
+	clock     Clock
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	callbacks []func(*amqp.Connection) error
+
+	ready     chan struct{}
+	readyOnce sync.Once
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewReconnectingConnection(cfg RabbitConfig) *ReconnectingConnection {
+	return &ReconnectingConnection{
+		cfg:       cfg,
+		dial:      NewRabbitConnection,
+		closeConn: (*amqp.Connection).Close,
+		clock:     realClock{},
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// OnReconnect registers fn to run after every successful connect, including
+// the first one. Consumers use it to reopen channels and redeclare queues.
+func (rc *ReconnectingConnection) OnReconnect(fn func(*amqp.Connection) error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.callbacks = append(rc.callbacks, fn)
+}
+
+// Start begins the connect/watch loop and blocks until the first connection
+// is established. A broker that is down at startup is retried indefinitely;
+// only config errors or Close end the wait early.
+func (rc *ReconnectingConnection) Start() error {
+	if _, err := rc.cfg.URI(); err != nil {
+		return err
+	}
+
+	go rc.run()
+
+	select {
+	case <-rc.ready:
+		return nil
+	case <-rc.done:
+		return ErrConnectionClosed
+	}
+}
+
+// Connection returns the current live connection, or nil while reconnecting.
+func (rc *ReconnectingConnection) Connection() *amqp.Connection {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.conn
+}
+
+// Close stops reconnecting and closes the current connection.
+func (rc *ReconnectingConnection) Close() error {
+	var err error
+	rc.closeOnce.Do(func() {
+		close(rc.done)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		if rc.conn != nil && !rc.conn.IsClosed() {
+			err = rc.closeConn(rc.conn)
+		}
+		rc.conn = nil
+	})
+	return err
+}
+
+func (rc *ReconnectingConnection) run() {
+	// failures counts consecutive connections whose callbacks failed, so a
+	// broker that accepts connections but rejects the setup is not redialed
+	// in a tight loop.
+	failures := 0
+	for {
+		conn := rc.dialWithBackoff()
+		if conn == nil {
+			return
+		}
+
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		rc.mu.Lock()
+		select {
+		case <-rc.done:
+			rc.mu.Unlock()
+			rc.closeConn(conn)
+			return
+		default:
+		}
+		rc.conn = conn
+		callbacks := append([]func(*amqp.Connection) error(nil), rc.callbacks...)
+		rc.mu.Unlock()
+
+		if err := runCallbacks(conn, callbacks); err != nil {
+			delay := backoffDelay(failures)
+			failures++
+			slog.Error("rabbitmq reconnect callback failed, redialing", "attempt", failures, "retry_in", delay, "error", err)
+			rc.mu.Lock()
+			rc.conn = nil
+			rc.mu.Unlock()
+			rc.closeConn(conn)
+
+			select {
+			case <-rc.clock.After(delay):
+				continue
+			case <-rc.done:
+				return
+			}
+		}
+		failures = 0
+		rc.readyOnce.Do(func() { close(rc.ready) })
+
+		select {
+		case amqpErr := <-closed:
+			rc.mu.Lock()
+			rc.conn = nil
+			rc.mu.Unlock()
+			if amqpErr != nil {
+				slog.Warn("rabbitmq connection lost", "error", amqpErr)
+			}
+		case <-rc.done:
+			return
+		}
+	}
+}
+
+func runCallbacks(conn *amqp.Connection, callbacks []func(*amqp.Connection) error) error {
+	for _, fn := range callbacks {
+		if err := fn(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialWithBackoff keeps dialing until it succeeds or the connection is
+// closed, in which case it returns nil.
+func (rc *ReconnectingConnection) dialWithBackoff() *amqp.Connection {
+	for attempt := 0; ; attempt++ {
+		conn, err := rc.dial(rc.cfg)
+		if err == nil {
+			return conn
+		}
+
+		delay := backoffDelay(attempt)
+		slog.Warn("rabbitmq dial failed, retrying", "attempt", attempt+1, "retry_in", delay, "error", err)
+
+		select {
+		case <-rc.clock.After(delay):
+		case <-rc.done:
+			return nil
+		}
+	}
+}
+
+// backoffDelay returns 1s, 2s, 4s, ... capped at reconnectMaxDelay.
+func backoffDelay(attempt int) time.Duration {
+	if attempt >= 5 {
+		return reconnectMaxDelay
+	}
+	delay := reconnectBaseDelay << attempt
+	if delay > reconnectMaxDelay {
+		return reconnectMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,105 @@
+package media
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDialWithBackoffRetries(t *testing.T) {
+	clock := newFakeClock()
+	want := &amqp.Connection{}
+	failures := 3
+
+	rc := NewReconnectingConnection(RabbitConfig{})
+	rc.clock = clock
+	rc.dial = func(RabbitConfig) (*amqp.Connection, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("connection refused")
+		}
+		return want, nil
+	}
+
+	got := make(chan *amqp.Connection, 1)
+	go func() { got <- rc.dialWithBackoff() }()
+
+	for _, wantDelay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if d := clock.nextWait(t); d != wantDelay {
+			t.Fatalf("backoff = %v, want %v", d, wantDelay)
+		}
+		clock.Advance(wantDelay)
+	}
+
+	select {
+	case conn := <-got:
+		if conn != want {
+			t.Fatalf("dialWithBackoff returned %p, want %p", conn, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialWithBackoff did not return after the dial succeeded")
+	}
+}
+
+func TestDialWithBackoffStopsOnClose(t *testing.T) {
+	clock := newFakeClock()
+	rc := NewReconnectingConnection(RabbitConfig{})
+	rc.clock = clock
+	rc.dial = func(RabbitConfig) (*amqp.Connection, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	got := make(chan *amqp.Connection, 1)
+	go func() { got <- rc.dialWithBackoff() }()
+
+	clock.nextWait(t)
+	rc.Close()
+
+	select {
+	case conn := <-got:
+		if conn != nil {
+			t.Fatal("dialWithBackoff returned a connection after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialWithBackoff kept retrying after Close")
+	}
+}
+
+func TestRunBacksOffWhenCallbacksFail(t *testing.T) {
+	clock := newFakeClock()
+	rc := NewReconnectingConnection(RabbitConfig{})
+	rc.clock = clock
+	dials := 0
+	rc.dial = func(RabbitConfig) (*amqp.Connection, error) {
+		dials++
+		return &amqp.Connection{}, nil
+	}
+	rc.closeConn = func(*amqp.Connection) error { return nil }
+	rc.OnReconnect(func(*amqp.Connection) error { return errors.New("declare queue: access refused") })
+
+	stopped := make(chan struct{})
+	go func() {
+		rc.run()
+		close(stopped)
+	}()
+
+	for _, wantDelay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if d := clock.nextWait(t); d != wantDelay {
+			t.Fatalf("backoff after callback failure = %v, want %v", d, wantDelay)
+		}
+		clock.Advance(wantDelay)
+	}
+	clock.nextWait(t)
+	rc.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run kept going after Close")
+	}
+	if dials != 4 {
+		t.Errorf("dialed %d times, want 4", dials)
+	}
+}
@@ -0,0 +1,127 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ReplayChannel is what ReplayDeadLetters needs from a channel.
+type ReplayChannel interface {
+	AMQPChannel
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+}
+
+var _ ReplayChannel = (*amqp.Channel)(nil)
+
+// replayedHeader marks a job ReplayDeadLetters put back, which keeps it
+// from being charged to its chat's quota a second time.
+const replayedHeader = "x-replayed"
+
+// ReplayDeadLetters moves jobs from the dead-letter queue back onto the
+// media queue each was dead-lettered from, with their death and retry
+// headers cleared so they get a fresh set of attempts. It stops once the
+// dead-letter queue is empty or limit jobs have been moved, with zero
+// meaning no limit, and returns how many were replayed. Each job is only
+// acked off the dead-letter queue after the broker confirms the copy.
+//
+// It reads with basic.get rather than a consumer, so it must run on its own
+// instead of alongside StartConsumer.
+func ReplayDeadLetters(ctx context.Context, ch ReplayChannel, cfg ConsumerConfig, limit int) (int, error) {
+	if !cfg.SkipDeclare {
+		if err := DeclareDeadLetter(ch, cfg.DLX); err != nil {
+			return 0, err
+		}
+	}
+	if err := ch.Confirm(false); err != nil {
+		return 0, fmt.Errorf("enable publisher confirms: %w", err)
+	}
+
+	dlq := deadLetterQueue(cfg.DLX)
+	replayed := 0
+	for limit == 0 || replayed < limit {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		d, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return replayed, fmt.Errorf("get from %s: %w", dlq, err)
+		}
+		if !ok {
+			break
+		}
+
+		queue := deadLetterOrigin(d, cfg)
+		if err := publishReplay(ctx, ch, queue, d); err != nil {
+			if nackErr := d.Nack(false, true); nackErr != nil {
+				slog.ErrorContext(ctx, "nack failed", "message_id", d.MessageId, "error", nackErr)
+			}
+			return replayed, fmt.Errorf("replay %s to %s: %w", deliveryID(d), queue, err)
+		}
+		if err := d.Ack(false); err != nil {
+			return replayed, fmt.Errorf("ack %s on %s: %w", deliveryID(d), dlq, err)
+		}
+		replayed++
+		slog.DebugContext(ctx, "replayed dead letter", "message_id", d.MessageId, "queue", queue)
+	}
+	return replayed, nil
+}
+
+// deadLetterOrigin is the media queue d was dead-lettered from, taken from
+// the most recent x-death entry, falling back to the first consumed queue.
+func deadLetterOrigin(d amqp.Delivery, cfg ConsumerConfig) string {
+	if deaths, ok := d.Headers["x-death"].([]any); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			if queue, ok := death["queue"].(string); ok && queue != "" {
+				return queue
+			}
+		}
+	}
+	return cfg.Queues[0].Name
+}
+
+// publishReplay republishes d to queue without the headers RabbitMQ and
+// republishForRetry added while it was failing, marked with replayedHeader.
+func publishReplay(ctx context.Context, ch ReplayChannel, queue string, d amqp.Delivery) error {
+	headers := amqp.Table{replayedHeader: true}
+	for k, v := range d.Headers {
+		if k == retryCountHeader || k == "x-death" || strings.HasPrefix(k, "x-first-death-") || strings.HasPrefix(k, "x-last-death-") {
+			continue
+		}
+		headers[k] = v
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	// The channel was put in confirm mode above, so a nil confirmation only
+	// comes from channels that cannot confirm at all.
+	if confirm == nil {
+		return nil
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("broker nacked the copy")
+	}
+	return nil
+}
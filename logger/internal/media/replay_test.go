@@ -0,0 +1,66 @@
+package media
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestReplayDeadLetters(t *testing.T) {
+	cfg := ConsumerConfig{Queues: []QueueConfig{{Name: "media.urgent"}, {Name: "media.bulk"}}, DLX: "media.dead"}
+	dlq := deadLetterQueue(cfg.DLX)
+
+	tests := []struct {
+		name         string
+		limit        int
+		wantReplayed int
+	}{
+		{name: "drain", wantReplayed: 3},
+		{name: "limit", limit: 2, wantReplayed: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newFakeChannel()
+			death := amqp.Table{"queue": "media.bulk", "reason": "rejected", "count": int64(1)}
+			first := ch.enqueue(dlq, amqp.Publishing{MessageId: "m1", Headers: amqp.Table{
+				"x-death":             []any{death},
+				"x-first-death-queue": "media.bulk",
+				retryCountHeader:      int32(3),
+				"x-correlation-id":    "corr-1",
+			}})
+			ch.enqueue(dlq, amqp.Publishing{MessageId: "m2"})
+			ch.enqueue(dlq, amqp.Publishing{MessageId: "m3"})
+
+			n, err := ReplayDeadLetters(context.Background(), ch, cfg, tt.limit)
+			if err != nil {
+				t.Fatalf("ReplayDeadLetters: %v", err)
+			}
+			if n != tt.wantReplayed || len(ch.published) != n || len(ch.acked) != n {
+				t.Fatalf("replayed %d, published %d, acked %d; want %d", n, len(ch.published), len(ch.acked), tt.wantReplayed)
+			}
+			if left := len(ch.waiting[dlq]); left != 3-n {
+				t.Errorf("%d left on the dead-letter queue, want %d", left, 3-n)
+			}
+
+			got := ch.published[0]
+			if got.Key != "media.bulk" || ch.acked[0] != first {
+				t.Errorf("first replay went to %q, acked %v", got.Key, ch.acked)
+			}
+			for _, h := range []string{"x-death", "x-first-death-queue", retryCountHeader} {
+				if _, ok := got.Msg.Headers[h]; ok {
+					t.Errorf("replayed message kept %s", h)
+				}
+			}
+			if got.Msg.Headers[replayedHeader] != true {
+				t.Errorf("replayed message is not marked: %v", got.Msg.Headers)
+			}
+			if got.Msg.Headers["x-correlation-id"] != "corr-1" {
+				t.Errorf("replayed message lost its other headers: %v", got.Msg.Headers)
+			}
+			if ch.published[1].Key != "media.urgent" {
+				t.Errorf("message without x-death went to %q, want the first queue", ch.published[1].Key)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var _ RangeDownloader = (*TelegramClient)(nil)
+
+// activePartials holds the partial download paths a worker is writing, so
+// two jobs for the same file never append to one file.
+var activePartials sync.Map
+
+// partialPath is where the download of fileID is kept between attempts.
+// It only depends on fileID, so a redelivered job finds what the last
+// delivery wrote.
+func partialPath(fileID string) string {
+	sum := sha256.Sum256([]byte(fileID))
+	return filepath.Join(os.TempDir(), "media-partial-"+hex.EncodeToString(sum[:12]))
+}
+
+// interruptedError is a download that failed partway through the body.
+// What arrived is kept, and the next attempt resumes after it.
+type interruptedError struct {
+	err error
+}
+
+func (e *interruptedError) Error() string { return "download interrupted: " + e.err.Error() }
+func (e *interruptedError) Unwrap() error { return e.err }
+
+// partialFile is a finished download of size bytes; closing it removes it.
+type partialFile struct {
+	*os.File
+	size    int64
+	release func()
+}
+
+func (f *partialFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	f.release()
+	return err
+}
+
+// resumableDownload downloads fileID into its partial file, resuming from
+// whatever an earlier attempt, in this job or an earlier delivery of it,
+// already wrote there. On top of the failures retryableDownload retries, a
+// body that breaks off partway is retried from where it stopped. The file
+// is kept when the download fails in a way a later attempt could pick up,
+// and removed otherwise; what is kept goes once the job runs out of
+// retries. It returns the complete file rewound.
+func resumableDownload(ctx context.Context, tg RangeDownloader, fileID string, maxBytes int64, maxRetries int) (io.ReadCloser, error) {
+	path := partialPath(fileID)
+	if _, busy := activePartials.LoadOrStore(path, struct{}{}); busy {
+		slog.DebugContext(ctx, "file already downloading elsewhere, not resuming", "file_id", fileID)
+		return retryableDownload(ctx, tg, fileID, maxBytes, maxRetries)
+	}
+	release := func() { activePartials.Delete(path) }
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("open partial download: %w", err)
+	}
+	fail := func(err error) (io.ReadCloser, error) {
+		f.Close()
+		if !resumable(ctx, err) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			os.Remove(path)
+		}
+		release()
+		return nil, err
+	}
+
+	var lastErr error
+	attempts := 0
+	for attempts <= maxRetries {
+		attempts++
+		err := resumeInto(ctx, tg, f, fileID, maxBytes)
+		if err == nil {
+			size, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				return fail(err)
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return fail(err)
+			}
+			return &partialFile{File: f, size: size, release: release}, nil
+		}
+		lastErr = err
+
+		if !resumable(ctx, err) || attempts > maxRetries {
+			break
+		}
+
+		delay := downloadBackoff(attempts - 1)
+		slog.WarnContext(ctx, "download failed, retrying", "file_id", fileID, "attempt", attempts, "retry_in", delay.String(), "error", err)
+
+		select {
+		case <-downloadClock.After(delay):
+		case <-ctx.Done():
+			return fail(fmt.Errorf("download %s cancelled after %d attempts: %w", fileID, attempts, errors.Join(lastErr, ctx.Err())))
+		}
+	}
+	return fail(fmt.Errorf("download %s failed after %d attempts: %w", fileID, attempts, lastErr))
+}
+
+// discardPartials removes the partial downloads kept for fileIDs once the
+// job they belong to will not be delivered again. A partial another worker
+// is writing is left alone.
+func discardPartials(fileIDs ...string) {
+	for _, fileID := range fileIDs {
+		if fileID == "" {
+			continue
+		}
+		path := partialPath(fileID)
+		if _, busy := activePartials.LoadOrStore(path, struct{}{}); busy {
+			continue
+		}
+		os.Remove(path)
+		activePartials.Delete(path)
+	}
+}
+
+// resumable reports whether another attempt at a download that failed with
+// err could get further.
+func resumable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var interrupted *interruptedError
+	if errors.As(err, &interrupted) {
+		return true
+	}
+	return isRetryableDownloadError(err)
+}
+
+// resumeInto appends the rest of fileID to f, or rewrites f from the start
+// when the server will not serve a range.
+func resumeInto(ctx context.Context, tg RangeDownloader, f *os.File, fileID string, maxBytes int64) error {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	body, resumed, err := tg.DownloadTelegramFileFrom(ctx, fileID, maxBytes, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	switch {
+	case resumed:
+		slog.InfoContext(ctx, "resuming download", "file_id", fileID, "offset", offset)
+	case offset > 0:
+		slog.InfoContext(ctx, "download cannot resume, starting over", "file_id", fileID, "discarded", offset)
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(f, &limitedReader{r: body, limit: maxBytes - offset})
+	if errors.Is(err, ErrTooLarge) {
+		return ErrTooLarge
+	}
+	if err != nil {
+		return &interruptedError{err: err}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+// flakyRangeDownloader serves body, breaking the connection after cutAfter
+// bytes on the first request. Without ranges it ignores offsets like a
+// server that does not support them.
+type flakyRangeDownloader struct {
+	body     string
+	cutAfter int
+	ranges   bool
+	offsets  []int64
+}
+
+func (d *flakyRangeDownloader) DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	body, _, err := d.DownloadTelegramFileFrom(ctx, fileID, maxBytes, 0)
+	return body, err
+}
+
+func (d *flakyRangeDownloader) DownloadTelegramFileFrom(ctx context.Context, fileID string, maxBytes, offset int64) (io.ReadCloser, bool, error) {
+	d.offsets = append(d.offsets, offset)
+	resumed := d.ranges && offset > 0
+	rest := d.body
+	if resumed {
+		rest = d.body[offset:]
+	}
+	if len(d.offsets) == 1 {
+		return io.NopCloser(io.MultiReader(strings.NewReader(rest[:d.cutAfter]), errReader{io.ErrUnexpectedEOF})), resumed, nil
+	}
+	return io.NopCloser(strings.NewReader(rest)), resumed, nil
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func readDownload(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+	defer body.Close()
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestResumableDownload(t *testing.T) {
+	const body = "0123456789abcdefghij"
+	tests := []struct {
+		name        string
+		ranges      bool
+		wantOffsets []int64
+	}{
+		{name: "resumes with range", ranges: true, wantOffsets: []int64{0, 8}},
+		{name: "restarts without range", ranges: false, wantOffsets: []int64{0, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			d := &flakyRangeDownloader{body: body, cutAfter: 8, ranges: tt.ranges}
+
+			got, err := resumableDownload(context.Background(), d, "file", 1<<20, 1)
+			if err != nil {
+				t.Fatalf("resumableDownload: %v", err)
+			}
+			if s := readDownload(t, got); s != body {
+				t.Errorf("downloaded %q, want %q", s, body)
+			}
+			if fmt.Sprint(d.offsets) != fmt.Sprint(tt.wantOffsets) {
+				t.Errorf("requested offsets %v, want %v", d.offsets, tt.wantOffsets)
+			}
+			if _, err := os.Stat(partialPath("file")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("partial file left behind after close: %v", err)
+			}
+		})
+	}
+}
+
+func TestResumableDownloadAcrossDeliveries(t *testing.T) {
+	const body = "0123456789abcdefghij"
+	t.Setenv("TMPDIR", t.TempDir())
+	d := &flakyRangeDownloader{body: body, cutAfter: 12, ranges: true}
+
+	if _, err := resumableDownload(context.Background(), d, "file", 1<<20, 0); err == nil {
+		t.Fatal("first delivery succeeded despite the broken body")
+	}
+	if info, err := os.Stat(partialPath("file")); err != nil || info.Size() != 12 {
+		t.Fatalf("partial file after failure = %v, %v; want 12 bytes kept", info, err)
+	}
+
+	got, err := resumableDownload(context.Background(), d, "file", 1<<20, 0)
+	if err != nil {
+		t.Fatalf("second delivery: %v", err)
+	}
+	if s := readDownload(t, got); s != body {
+		t.Errorf("downloaded %q, want %q", s, body)
+	}
+	if d.offsets[1] != 12 {
+		t.Errorf("second delivery started at %d, want 12", d.offsets[1])
+	}
+}
+
+// rangeServer answers getFile and serves body, honouring Range headers
+// when ranges is set.
+type rangeServer struct {
+	body   string
+	ranges bool
+	seen   []string
+}
+
+func (s *rangeServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+	if strings.Contains(req.URL.Path, "/getFile") {
+		resp.Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{"ok":true,"result":{"file_path":"videos/f.mp4","file_size":%d}}`, len(s.body))))
+		return resp, nil
+	}
+
+	s.seen = append(s.seen, req.Header.Get("Range"))
+	rest := s.body
+	if r := req.Header.Get("Range"); r != "" && s.ranges {
+		offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r, "bytes="), "-"))
+		rest = s.body[offset:]
+		resp.StatusCode = http.StatusPartialContent
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(s.body)-1, len(s.body)))
+	}
+	resp.ContentLength = int64(len(rest))
+	resp.Body = io.NopCloser(strings.NewReader(rest))
+	return resp, nil
+}
+
+func TestTelegramClientRange(t *testing.T) {
+	for _, ranges := range []bool{true, false} {
+		t.Run("ranges="+strconv.FormatBool(ranges), func(t *testing.T) {
+			srv := &rangeServer{body: "0123456789", ranges: ranges}
+			c := NewTelegramClient(TelegramConfig{BotToken: "token", RPS: 100}, &http.Client{Transport: srv})
+
+			body, resumed, err := c.DownloadTelegramFileFrom(context.Background(), "f", 1<<20, 4)
+			if err != nil {
+				t.Fatalf("DownloadTelegramFileFrom: %v", err)
+			}
+			got := readDownload(t, body)
+			if resumed != ranges {
+				t.Errorf("resumed = %v, want %v", resumed, ranges)
+			}
+			want := "0123456789"
+			if ranges {
+				want = "456789"
+			}
+			if got != want {
+				t.Errorf("body = %q, want %q", got, want)
+			}
+			if srv.seen[0] != "bytes=4-" {
+				t.Errorf("Range header = %q, want bytes=4-", srv.seen[0])
+			}
+		})
+	}
+}
+
+// brokenRangeDownloader breaks every response off after cutAfter bytes.
+type brokenRangeDownloader struct {
+	body     string
+	cutAfter int
+}
+
+func (d brokenRangeDownloader) DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	body, _, err := d.DownloadTelegramFileFrom(ctx, fileID, maxBytes, 0)
+	return body, err
+}
+
+func (d brokenRangeDownloader) DownloadTelegramFileFrom(ctx context.Context, fileID string, maxBytes, offset int64) (io.ReadCloser, bool, error) {
+	return io.NopCloser(io.MultiReader(strings.NewReader(d.body[:d.cutAfter]), errReader{io.ErrUnexpectedEOF})), false, nil
+}
+
+func TestPartialDownloadRemovedWhenRetriesRunOut(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mediaProcessor{
+		telegram:         brokenRangeDownloader{body: "%PDF-1.4\nnever arrives whole", cutAfter: 12},
+		storage:          storage,
+		dedup:            NewDedupCache(0),
+		index:            NewStorageIndex(storage),
+		publisher:        &recordingPublisher{},
+		metrics:          metrics.New(),
+		downloads:        TelegramConfig{MaxFileBytes: 1 << 20, ResumableDownloads: true},
+		allowedMIMETypes: defaultAllowedMIMETypes,
+		maxRetries:       1,
+	}
+	body := []byte(`{"job_id":"j1","chat_id":1,"file_id":"big","file_unique_id":"u","media_type":"document","requested_at":"2024-01-01T00:00:00Z"}`)
+	ctx := context.Background()
+
+	if err := p.handleMediaJob(ctx, "media.test", amqp.Delivery{Body: body}); err == nil {
+		t.Fatal("first delivery succeeded despite the broken body")
+	}
+	if _, err := os.Stat(partialPath("big")); err != nil {
+		t.Fatalf("partial file not kept for the retry: %v", err)
+	}
+
+	last := amqp.Delivery{Body: body, Headers: amqp.Table{retryCountHeader: int32(1)}}
+	if err := p.handleMediaJob(ctx, "media.test", last); err == nil {
+		t.Fatal("last delivery succeeded despite the broken body")
+	}
+	if _, err := os.Stat(partialPath("big")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial file left behind after the last delivery: %v", err)
+	}
+}
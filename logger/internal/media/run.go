@@ -0,0 +1,184 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+// Deps is everything Run needs that talks to the outside world. cmd/media
+// builds the real implementations; tests can pass fakes. Scanner is only
+// consulted when ENABLE_SCAN is set, and falls back to NoopScanner if nil.
+type Deps struct {
+	Config    Config
+	Broker    Broker
+	Telegram  Downloader
+	Storage   Storage
+	Dedup     DedupCache
+	Index     MetadataIndex
+	Publisher ResultPublisher
+	Scanner   Scanner
+	Metrics   *metrics.Metrics
+}
+
+// ErrShutdownTimeout is returned by Run when in-flight jobs were still
+// running once SHUTDOWN_TIMEOUT elapsed.
+var ErrShutdownTimeout = errors.New("shutdown timed out")
+
+// Run consumes media jobs until ctx is cancelled, then drains in-flight jobs
+// and shuts down. It returns nil after a clean shutdown.
+func Run(ctx context.Context, deps Deps) error {
+	cfg := deps.Config
+	processor := &mediaProcessor{
+		telegram:   deps.Telegram,
+		storage:    deps.Storage,
+		dedup:      deps.Dedup,
+		index:      deps.Index,
+		publisher:  deps.Publisher,
+		metrics:    deps.Metrics,
+		downloads:  cfg.Telegram,
+		keys:       cfg.StorageKeys,
+		maxRetries: cfg.Consumer.MaxRetries,
+		maxJobAge:  cfg.MaxJobAge,
+		dryRun:     cfg.DryRun,
+		jobTimeout: cfg.JobTimeout,
+		batchMode:  cfg.BatchFailureMode,
+		quota:      newChatQuota(cfg.PerChatLimit, cfg.PerChatWindow, realClock{}),
+		inflight:   newByteBudget(cfg.MaxInflightBytes, deps.Metrics.InflightBytes),
+
+		thumbnailMaxDim:  cfg.ThumbnailMaxDim,
+		allowedMIMETypes: cfg.AllowedMIMETypes,
+		stripEXIF:        cfg.StripEXIF,
+		jpegQuality:      cfg.JPEGQuality,
+	}
+
+	if cfg.DryRun {
+		slog.Warn("dry run: jobs are logged and acked, nothing is downloaded, stored or published")
+	}
+	if cfg.Storage.signsURLs() {
+		processor.signedURLTTL = cfg.Storage.SignedURLTTL
+	}
+	if cfg.Scan.Enabled {
+		processor.scanner = deps.Scanner
+		if processor.scanner == nil {
+			processor.scanner = NoopScanner{}
+		}
+	}
+
+	// Job work runs on its own context so a shutdown signal stops new
+	// deliveries without cutting off jobs that are already running.
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	tracker := newJobTracker()
+	health := newHealthState(deps.Broker)
+	deps.Broker.OnReconnect(func(conn *amqp.Connection) error {
+		return serve(ctx, jobCtx, conn, cfg.Consumer, cfg.Results, tracker, processor, health)
+	})
+
+	healthSrv := startHealthServer(cfg.HealthPort, health, deps.Metrics.Handler(), mediaHandler(deps.Index, deps.Storage), quotaHandler(processor.quota))
+
+	started := make(chan error, 1)
+	go func() { started <- deps.Broker.Start() }()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			healthSrv.Close()
+			return fmt.Errorf("rabbitmq connection failed: %w", err)
+		}
+	case <-ctx.Done():
+		deps.Broker.Close()
+		healthSrv.Close()
+		return nil
+	}
+
+	slog.Info("connected to rabbitmq", "addr", net.JoinHostPort(cfg.Rabbit.Host, cfg.Rabbit.Port))
+
+	stopDepthPoller := startQueueDepthPoller(brokerInspector(deps.Broker), cfg.Consumer.Queues, cfg.Consumer.DepthPollInterval, deps.Metrics.QueueDepth)
+
+	<-ctx.Done()
+	stopDepthPoller()
+	return shutdown(deps.Broker, tracker, cfg.ShutdownTimeout, cancelJobs, healthSrv)
+}
+
+// serve declares the topology unless cfg.SkipDeclare is set, then opens a
+// channel on conn and consumes the media queue on it until ctx is
+// cancelled, running each job under jobCtx. It is re-run after every
+// reconnect.
+func serve(ctx, jobCtx context.Context, conn *amqp.Connection, cfg ConsumerConfig, results ResultsConfig, tracker *jobTracker, processor *mediaProcessor, health *healthState) error {
+	if !cfg.SkipDeclare {
+		open := func() (topologyChannel, error) { return conn.Channel() }
+		if err := DeclareTopology(open, cfg, results); err != nil {
+			return err
+		}
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("open channel: %w", err)
+	}
+
+	// Results go out on their own channel so a publish error cannot tear
+	// down the consumer.
+	pubCh, err := conn.Channel()
+	if err != nil {
+		ch.Close()
+		return fmt.Errorf("open publish channel: %w", err)
+	}
+	if err := processor.publisher.SetChannel(pubCh); err != nil {
+		ch.Close()
+		pubCh.Close()
+		return err
+	}
+
+	go func() {
+		defer ch.Close()
+		defer pubCh.Close()
+		health.setConsuming(true)
+		err := StartConsumer(ctx, ch, cfg, tracker, func(queue string, d amqp.Delivery) error {
+			err := processor.handleMediaJob(withCorrelationID(jobCtx, d.CorrelationId), queue, d)
+			if err == nil {
+				health.markSuccess()
+			}
+			return err
+		})
+		health.setConsuming(false)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("consumer stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// shutdown waits for in-flight jobs, cancelling whatever is left once the
+// timeout elapses, then stops the health server and closes the broker
+// connection.
+func shutdown(broker Broker, tracker *jobTracker, timeout time.Duration, cancelJobs context.CancelFunc, healthSrv *http.Server) error {
+	abandoned := tracker.Wait(timeout)
+	cancelJobs()
+
+	serverCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := healthSrv.Shutdown(serverCtx); err != nil {
+		slog.Error("stopping health server", "error", err)
+	}
+
+	if err := broker.Close(); err != nil {
+		slog.Error("closing rabbitmq connection", "error", err)
+	}
+
+	if len(abandoned) > 0 {
+		slog.Error("shutdown timed out", "timeout", timeout, "abandoned_jobs", abandoned)
+		return ErrShutdownTimeout
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
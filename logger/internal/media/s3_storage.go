@@ -0,0 +1,168 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config points at an S3-compatible endpoint such as the MinIO in infra/.
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Secure    bool
+}
+
+// LoadS3Config reads the S3_* settings shared with the other services.
+func LoadS3Config() (S3Config, error) {
+	cfg := S3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		Bucket:    os.Getenv("S3_BUCKET_NAME"),
+	}
+
+	if raw := os.Getenv("S3_SECURE"); raw != "" {
+		secure, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid S3_SECURE: %q", raw)
+		}
+		cfg.Secure = secure
+	}
+	return cfg, nil
+}
+
+// Validate reports every missing S3 setting.
+func (cfg S3Config) Validate() error {
+	return requireEnv(
+		envField{"S3_ENDPOINT", cfg.Endpoint},
+		envField{"S3_ACCESS_KEY", cfg.AccessKey},
+		envField{"S3_SECRET_KEY", cfg.SecretKey},
+		envField{"S3_BUCKET_NAME", cfg.Bucket},
+	)
+}
+
+// s3PartSize is the multipart chunk PutObject buffers when it does not know
+// the object size. minio-go's default would allocate over 500 MiB per Put.
+const s3PartSize = 16 << 20
+
+// S3Storage stores media in a bucket on an S3-compatible endpoint.
+type S3Storage struct {
+	client *minio.Client
+	cfg    S3Config
+}
+
+// NewS3Storage connects to the endpoint and creates the bucket if needed.
+func NewS3Storage(ctx context.Context, cfg S3Config) (*S3Storage, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client: %w", err)
+	}
+
+	found, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check bucket %s: %w", cfg.Bucket, err)
+	}
+	if !found {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, fmt.Errorf("create bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+	return &S3Storage{client: client, cfg: cfg}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	// An object only becomes visible once PutObject completes, and a failed
+	// multipart upload is aborted, so readers never see a partial object.
+	opts := minio.PutObjectOptions{ContentType: contentType, PartSize: s3PartSize}
+	if _, err := s.client.PutObject(ctx, s.cfg.Bucket, key, r, readerSize(r), opts); err != nil {
+		return "", fmt.Errorf("put %s: %w", key, err)
+	}
+	return s.URL(key), nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (*Object, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// GetObject is lazy, so stat first to surface a missing key as ErrNotFound.
+	info, err := s.client.StatObject(ctx, s.cfg.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("stat %s: %w", key, err)
+	}
+
+	obj, err := s.client.GetObject(ctx, s.cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	return &Object{ReadCloser: obj, ContentType: contentType, Size: info.Size, LastModified: info.LastModified}, nil
+}
+
+func (s *S3Storage) Verify(ctx context.Context, key, expectedSHA string) (bool, error) {
+	return verifyObject(ctx, s, key, expectedSHA)
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for obj := range s.client.ListObjects(ctx, s.cfg.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, obj.Err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// maxSignedURLTTL is the longest expiry SigV4 presigned URLs allow.
+const maxSignedURLTTL = 7 * 24 * time.Hour
+
+// SignedURL presigns a GET for key that expires after ttl, which must be
+// between one second and seven days.
+func (s *S3Storage) SignedURL(key string, ttl time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if ttl < time.Second || ttl > maxSignedURLTTL {
+		return "", fmt.Errorf("sign %s: ttl %s out of range", key, ttl)
+	}
+	u, err := s.client.PresignedGetObject(context.Background(), s.cfg.Bucket, key, ttl, nil)
+	if err != nil {
+		return "", fmt.Errorf("sign %s: %w", key, err)
+	}
+	return u.String(), nil
+}
+
+func (s *S3Storage) URL(key string) string {
+	scheme := "http"
+	if s.cfg.Secure {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: s.cfg.Endpoint, Path: "/" + s.cfg.Bucket + "/" + key}
+	return u.String()
+}
@@ -0,0 +1,182 @@
+package media
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClamdTimeout = 60 * time.Second
+	clamdChunkSize      = 64 << 10
+)
+
+// ScanResult is a scanner's verdict on one file.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner inspects downloaded media before it is stored.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// ScanConfig turns scanning on and says where clamd listens.
+type ScanConfig struct {
+	Enabled   bool
+	ClamdAddr string
+	Timeout   time.Duration
+}
+
+// LoadScanConfig reads ENABLE_SCAN, CLAMD_ADDR and CLAMD_TIMEOUT.
+func LoadScanConfig() (ScanConfig, error) {
+	enabled, enabledErr := envBool("ENABLE_SCAN", false)
+	timeout, timeoutErr := envDuration("CLAMD_TIMEOUT", defaultClamdTimeout)
+	return ScanConfig{
+		Enabled:   enabled,
+		ClamdAddr: os.Getenv("CLAMD_ADDR"),
+		Timeout:   timeout,
+	}, errors.Join(enabledErr, timeoutErr)
+}
+
+// Validate requires CLAMD_ADDR once scanning is enabled.
+func (cfg ScanConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	return requireEnv(envField{"CLAMD_ADDR", cfg.ClamdAddr})
+}
+
+// NewScanner returns a ClamAVScanner for cfg, or a NoopScanner when
+// scanning is disabled.
+func NewScanner(cfg ScanConfig) Scanner {
+	if !cfg.Enabled {
+		return NoopScanner{}
+	}
+	return &ClamAVScanner{Addr: cfg.ClamdAddr, Timeout: cfg.Timeout}
+}
+
+// NoopScanner reports every file as clean without reading it.
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	return ScanResult{}, nil
+}
+
+// ClamAVScanner streams files to clamd with the INSTREAM command. Addr is
+// host:port, or unix:/path/to/clamd.sock for a local socket.
+type ClamAVScanner struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func (s *ClamAVScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	network, addr := "tcp", s.Addr
+	if path, ok := strings.CutPrefix(s.Addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := clamdInstream(conn, r); err != nil {
+		return ScanResult{}, fmt.Errorf("stream to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return ScanResult{}, fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// clamdInstream sends r to clamd as length-prefixed chunks followed by the
+// zero-length terminator, so only one chunk is held in memory at a time.
+func clamdInstream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamdReply reads replies such as "stream: OK" and
+// "stream: Win.Test.EICAR_HDB-1 FOUND".
+func parseClamdReply(reply string) (ScanResult, error) {
+	_, verdict, _ := strings.Cut(reply, ": ")
+	switch {
+	case verdict == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// scanToTemp copies r into a temporary file while nothing is stored yet,
+// scans it from disk and returns the file rewound for storing. Spooling
+// keeps large files out of memory and means an infected file never reaches
+// storage. The caller must close and remove the file.
+func scanToTemp(ctx context.Context, scanner Scanner, r io.Reader) (*os.File, ScanResult, error) {
+	f, err := os.CreateTemp("", "media-scan-*")
+	if err != nil {
+		return nil, ScanResult{}, fmt.Errorf("create scan spool: %w", err)
+	}
+	fail := func(err error) (*os.File, ScanResult, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, ScanResult{}, err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	result, err := scanner.Scan(ctx, f)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return f, result, nil
+}
@@ -0,0 +1,80 @@
+package media
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts one INSTREAM session, checks the streamed bytes came
+// through intact and answers with reply.
+func fakeClamd(t *testing.T, wantBody, reply string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+			t.Errorf("command = %q, %v", cmd, err)
+			return
+		}
+		var body strings.Builder
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				t.Errorf("read chunk size: %v", err)
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&body, r, int64(size)); err != nil {
+				t.Errorf("read chunk: %v", err)
+				return
+			}
+		}
+		if body.String() != wantBody {
+			t.Errorf("clamd got %d bytes, want %d", body.Len(), len(wantBody))
+		}
+		io.WriteString(conn, reply+"\x00")
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamAVScanner(t *testing.T) {
+	body := strings.Repeat("media", 30000) // spans several chunks
+	tests := []struct {
+		reply string
+		want  ScanResult
+	}{
+		{reply: "stream: OK", want: ScanResult{}},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", want: ScanResult{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reply, func(t *testing.T) {
+			s := &ClamAVScanner{Addr: fakeClamd(t, body, tt.reply), Timeout: 5 * time.Second}
+			got, err := s.Scan(context.Background(), strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Scan = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
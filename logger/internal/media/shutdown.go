@@ -0,0 +1,68 @@
+package media
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// jobTracker records which media jobs are currently in flight so shutdown
+// can wait for them and report any it had to abandon.
+type jobTracker struct {
+	mu   sync.Mutex
+	jobs map[string]int
+	wg   sync.WaitGroup
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{jobs: make(map[string]int)}
+}
+
+func (t *jobTracker) Begin(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs[id]++
+	t.wg.Add(1)
+}
+
+func (t *jobTracker) Done(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.jobs[id] <= 1 {
+		delete(t.jobs, id)
+	} else {
+		t.jobs[id]--
+	}
+	t.wg.Done()
+}
+
+// Pending returns the IDs of jobs that have not finished yet, sorted.
+func (t *jobTracker) Pending() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.jobs))
+	for id := range t.jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Wait blocks until every in-flight job is done or the timeout elapses. On
+// timeout it returns the jobs that were still running.
+func (t *jobTracker) Wait(timeout time.Duration) []string {
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(timeout):
+		return t.Pending()
+	}
+}
@@ -0,0 +1,111 @@
+package media
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+// defaultAllowedMIMETypes covers what Telegram sends for photos, videos,
+// animations, voice notes and common documents.
+var defaultAllowedMIMETypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"video/mp4",
+	"video/webm",
+	"audio/mpeg",
+	"audio/ogg",
+	"application/ogg",
+	"application/pdf",
+}
+
+// Reasons reported with a rejected result.
+const (
+	RejectContentType  = "content_type_not_allowed"
+	RejectFileTooLarge = "file_too_large"
+	RejectInfected     = "infected"
+)
+
+// RejectedError is returned when a downloaded file is refused for its
+// detected content type, its size or a scanner finding. The job is dropped
+// without storing anything.
+type RejectedError struct {
+	Reason      string
+	ContentType string
+	Signature   string
+}
+
+func (e *RejectedError) Error() string {
+	switch e.Reason {
+	case RejectFileTooLarge:
+		return "file exceeds the size limit"
+	case RejectInfected:
+		return fmt.Sprintf("file is infected: %s", e.Signature)
+	default:
+		return fmt.Sprintf("content type %s is not allowed", e.ContentType)
+	}
+}
+
+func isRejected(err error) (*RejectedError, bool) {
+	var rejected *RejectedError
+	ok := errors.As(err, &rejected)
+	return rejected, ok
+}
+
+// loadAllowedMIMETypes reads ALLOWED_MIME_TYPES as a comma-separated list.
+// Entries may be exact types such as "image/png" or wildcards such as
+// "image/*".
+func loadAllowedMIMETypes() ([]string, error) {
+	raw := envString("ALLOWED_MIME_TYPES", "")
+	if raw == "" {
+		return defaultAllowedMIMETypes, nil
+	}
+
+	var allowed []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if major, minor, ok := strings.Cut(entry, "/"); !ok || major == "" || minor == "" {
+			return nil, fmt.Errorf("invalid ALLOWED_MIME_TYPES entry: %q", entry)
+		}
+		allowed = append(allowed, entry)
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("invalid ALLOWED_MIME_TYPES: %q", raw)
+	}
+	return allowed, nil
+}
+
+// sniffContentType detects the content type of r from its first bytes and
+// returns a reader that still yields the whole stream. Parameters such as
+// charset are dropped.
+func sniffContentType(r io.Reader) (string, io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", nil, fmt.Errorf("read file header: %w", err)
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return strings.TrimSpace(contentType), br, nil
+}
+
+// mimeAllowed reports whether contentType matches an entry in allowed.
+func mimeAllowed(allowed []string, contentType string) bool {
+	major, _, _ := strings.Cut(contentType, "/")
+	for _, entry := range allowed {
+		if entry == contentType || entry == "*/*" || entry == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
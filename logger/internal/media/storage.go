@@ -0,0 +1,181 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Storage.Get when no object exists under a key.
+var ErrNotFound = errors.New("storage object not found")
+
+// defaultContentType is reported for objects stored without a content type.
+const defaultContentType = "application/octet-stream"
+
+// Object is a stored object opened for reading.
+type Object struct {
+	io.ReadCloser
+	ContentType  string
+	Size         int64
+	LastModified time.Time
+}
+
+// Storage is where downloaded media ends up.
+type Storage interface {
+	// Put stores r under key with the given content type and returns a URL
+	// the object can be fetched from.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (url string, err error)
+	// Get opens the object stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (*Object, error)
+	// URL returns the address Put reports for key, without touching the backend.
+	URL(key string) string
+	// SignedURL returns an address for key that grants read access for ttl.
+	// Backends without access control return URL(key).
+	SignedURL(key string, ttl time.Duration) (string, error)
+	// Verify re-reads the object under key and reports whether its SHA-256
+	// is expectedSHA, given in hex.
+	Verify(ctx context.Context, key, expectedSHA string) (bool, error)
+	// List returns the keys of every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// sizedReader carries the length of a reader whose length the caller knows,
+// for backends that can use it up front.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+// readerSize returns the length of r, or -1 when it is not known.
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case sizedReader:
+		return v.size
+	case interface{ Len() int }:
+		return int64(v.Len())
+	}
+	return -1
+}
+
+const (
+	storageBackendLocal = "local"
+	storageBackendS3    = "s3"
+
+	defaultSignedURLTTL = time.Hour
+)
+
+// StorageConfig selects and configures the storage backend. SignedURLTTL is
+// how long the signed URLs in S3 results stay valid.
+type StorageConfig struct {
+	Backend      string
+	Dir          string
+	S3           S3Config
+	SignedURLTTL time.Duration
+}
+
+// LoadStorageConfig reads STORAGE_BACKEND, STORAGE_DIR, SIGNED_URL_TTL and
+// the S3_* settings.
+func LoadStorageConfig() (StorageConfig, error) {
+	s3, s3Err := LoadS3Config()
+	ttl, ttlErr := envDuration("SIGNED_URL_TTL", defaultSignedURLTTL)
+	return StorageConfig{
+		Backend:      envString("STORAGE_BACKEND", storageBackendLocal),
+		Dir:          envString("STORAGE_DIR", "./data"),
+		S3:           s3,
+		SignedURLTTL: ttl,
+	}, errors.Join(s3Err, ttlErr)
+}
+
+// signsURLs reports whether results should carry signed URLs.
+func (cfg StorageConfig) signsURLs() bool {
+	return cfg.Backend == storageBackendS3
+}
+
+// Validate checks the settings the chosen backend needs.
+func (cfg StorageConfig) Validate() error {
+	switch cfg.Backend {
+	case storageBackendLocal:
+		return requireEnv(envField{"STORAGE_DIR", cfg.Dir})
+	case storageBackendS3:
+		var ttlErr error
+		if cfg.SignedURLTTL <= 0 || cfg.SignedURLTTL > maxSignedURLTTL {
+			ttlErr = fmt.Errorf("invalid SIGNED_URL_TTL: %s, must be between 1s and %s", cfg.SignedURLTTL, maxSignedURLTTL)
+		}
+		return errors.Join(cfg.S3.Validate(), ttlErr)
+	default:
+		return fmt.Errorf("invalid STORAGE_BACKEND: %q", cfg.Backend)
+	}
+}
+
+// NewStorage builds the backend selected by cfg.Backend.
+func NewStorage(ctx context.Context, cfg StorageConfig) (Storage, error) {
+	switch cfg.Backend {
+	case storageBackendLocal:
+		return NewLocalStorage(cfg.Dir)
+	case storageBackendS3:
+		return NewS3Storage(ctx, cfg.S3)
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND: %q", cfg.Backend)
+	}
+}
+
+// storageKey is the key layout used before STORAGE_KEY_TEMPLATE: the
+// media type, then the FileUniqueID. lookupMedia still probes it for media
+// stored before the metadata index existed.
+func storageKey(mediaType MediaType, fileUniqueID string) string {
+	return string(mediaType) + "/" + sanitizeKeyPart(fileUniqueID)
+}
+
+// thumbnailKey is where the thumbnail for the object at key is stored.
+func thumbnailKey(key string) string {
+	return key + ".thumb.jpg"
+}
+
+// cleanKey rejects keys that could escape the storage root.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != key {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return cleaned, nil
+}
+
+// exists reports whether s already holds an object under key.
+func exists(ctx context.Context, s Storage, key string) (bool, error) {
+	obj, err := s.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	obj.Close()
+	return true, nil
+}
+
+// verifyObject hashes the object under key in s for Storage.Verify.
+func verifyObject(ctx context.Context, s Storage, key, expectedSHA string) (bool, error) {
+	obj, err := s.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer obj.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return false, fmt.Errorf("read %s: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)) == strings.ToLower(expectedSHA), nil
+}
+
+// isNotExist maps filesystem not-found errors onto ErrNotFound.
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,365 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultTelegramAPIBase = "https://api.telegram.org"
+	defaultMaxFileBytes    = 20 << 20 // the Bot API refuses getFile above 20MB
+	defaultTelegramRPS     = 25
+
+	// maxRateLimitRetries bounds how often a single call waits out a 429.
+	maxRateLimitRetries = 5
+)
+
+// ErrTooLarge is returned when a Telegram file exceeds its size limit.
+var ErrTooLarge = errors.New("telegram file exceeds the size limit")
+
+// TelegramConfig holds the Bot API credentials and download limits.
+// MaxFileBytesByType overrides MaxFileBytes for individual media types.
+// With ResumableDownloads set, an interrupted download picks up where it
+// stopped instead of starting over. APIBase points the client at a
+// self-hosted Bot API server instead of api.telegram.org.
+type TelegramConfig struct {
+	APIBase            string
+	BotToken           string
+	MaxFileBytes       int64
+	MaxFileBytesByType map[MediaType]int64
+	RPS                float64
+	DownloadMaxRetries int
+	ResumableDownloads bool
+}
+
+// LoadTelegramConfig reads TELEGRAM_API_BASE, TELEGRAM_BOT_TOKEN,
+// MAX_FILE_BYTES and its per-type MAX_FILE_BYTES_<TYPE> overrides,
+// TELEGRAM_RPS, DOWNLOAD_MAX_RETRIES and RESUMABLE_DOWNLOADS.
+func LoadTelegramConfig() (TelegramConfig, error) {
+	cfg := TelegramConfig{
+		BotToken:           os.Getenv("TELEGRAM_BOT_TOKEN"),
+		MaxFileBytes:       defaultMaxFileBytes,
+		MaxFileBytesByType: make(map[MediaType]int64),
+		RPS:                defaultTelegramRPS,
+	}
+
+	var errs []error
+	n, err := envBytes("MAX_FILE_BYTES", defaultMaxFileBytes)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.MaxFileBytes = n
+	for _, t := range mediaTypes {
+		name := "MAX_FILE_BYTES_" + strings.ToUpper(string(t))
+		if os.Getenv(name) == "" {
+			continue
+		}
+		n, err := envBytes(name, cfg.MaxFileBytes)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cfg.MaxFileBytesByType[t] = n
+	}
+	if raw := os.Getenv("TELEGRAM_RPS"); raw != "" {
+		rps, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rps <= 0 {
+			errs = append(errs, fmt.Errorf("invalid TELEGRAM_RPS: %q", raw))
+		} else {
+			cfg.RPS = rps
+		}
+	}
+	retries, err := envInt("DOWNLOAD_MAX_RETRIES", defaultDownloadMaxRetries, 0)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.DownloadMaxRetries = retries
+	resumable, err := envBool("RESUMABLE_DOWNLOADS", false)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.ResumableDownloads = resumable
+	base, err := loadAPIBase()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.APIBase = base
+
+	return cfg, errors.Join(errs...)
+}
+
+// loadAPIBase reads TELEGRAM_API_BASE, an http or https URL that may carry a
+// path prefix, with any trailing slash dropped so paths can be appended.
+func loadAPIBase() (string, error) {
+	raw := envString("TELEGRAM_API_BASE", defaultTelegramAPIBase)
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+		return "", fmt.Errorf("invalid TELEGRAM_API_BASE: %q", raw)
+	}
+	return strings.TrimRight(raw, "/"), nil
+}
+
+// MaxBytes returns the download size limit for media of type t.
+func (cfg TelegramConfig) MaxBytes(t MediaType) int64 {
+	if n, ok := cfg.MaxFileBytesByType[t]; ok {
+		return n
+	}
+	return cfg.MaxFileBytes
+}
+
+// Validate reports a missing bot token.
+func (cfg TelegramConfig) Validate() error {
+	return requireEnv(envField{"TELEGRAM_BOT_TOKEN", cfg.BotToken})
+}
+
+// TelegramAPIError is returned when the Bot API answers with ok:false.
+type TelegramAPIError struct {
+	Method      string
+	Code        int
+	Description string
+	RetryAfter  time.Duration
+}
+
+func (e *TelegramAPIError) Error() string {
+	return fmt.Sprintf("telegram %s failed (%d): %s", e.Method, e.Code, e.Description)
+}
+
+// DownloadStatusError is returned when the file endpoint answers with a
+// non-2xx status.
+type DownloadStatusError struct {
+	StatusCode int
+}
+
+func (e *DownloadStatusError) Error() string {
+	return fmt.Sprintf("telegram file download returned %d", e.StatusCode)
+}
+
+// Downloader fetches a Telegram file by its file ID, refusing files larger
+// than maxBytes.
+type Downloader interface {
+	DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error)
+}
+
+// RangeDownloader is a Downloader that can start partway into a file.
+// resumed reports whether the body starts at offset; it is false when the
+// server ignored the range and sent the whole file.
+type RangeDownloader interface {
+	Downloader
+	DownloadTelegramFileFrom(ctx context.Context, fileID string, maxBytes, offset int64) (body io.ReadCloser, resumed bool, err error)
+}
+
+// TelegramClient talks to the Bot API to resolve and fetch files. One
+// client is shared by every worker so its limiter caps the global request
+// rate, not the per-worker one, and its HTTP client's pool serves them all.
+type TelegramClient struct {
+	cfg     TelegramConfig
+	http    *http.Client
+	limiter *rate.Limiter
+}
+
+// NewTelegramClient sends every request through httpClient, typically one
+// built by NewHTTPClient.
+func NewTelegramClient(cfg TelegramConfig, httpClient *http.Client) *TelegramClient {
+	return &TelegramClient{
+		cfg:     cfg,
+		http:    httpClient,
+		limiter: rate.NewLimiter(rate.Limit(cfg.RPS), max(1, int(cfg.RPS))),
+	}
+}
+
+// apiBase is cfg.APIBase, or api.telegram.org when it is unset.
+func (c *TelegramClient) apiBase() string {
+	if c.cfg.APIBase == "" {
+		return defaultTelegramAPIBase
+	}
+	return c.cfg.APIBase
+}
+
+type telegramFile struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileSize     int64  `json:"file_size"`
+	FilePath     string `json:"file_path"`
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// DownloadTelegramFile resolves fileID with getFile and streams the file
+// body. Every media type resolves through getFile; only the size limit
+// differs. The caller must close the returned reader. The body is read
+// under ctx, so cancelling it aborts the request and frees the connection.
+func (c *TelegramClient) DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	body, _, err := c.DownloadTelegramFileFrom(ctx, fileID, maxBytes, 0)
+	return body, err
+}
+
+// DownloadTelegramFileFrom is DownloadTelegramFile starting offset bytes
+// into the file, with a Range request. A server that answers anything but
+// a 206 for exactly that range gets a plain request for the whole file.
+// When getFile or the response gives the size, the body carries it for the
+// in-flight byte budget.
+func (c *TelegramClient) DownloadTelegramFileFrom(ctx context.Context, fileID string, maxBytes, offset int64) (io.ReadCloser, bool, error) {
+	file, err := c.getFile(ctx, fileID)
+	if err != nil {
+		return nil, false, err
+	}
+	if file.FileSize > maxBytes {
+		return nil, false, ErrTooLarge
+	}
+	if offset > 0 && offset == file.FileSize {
+		// An earlier attempt got every byte; a range past the end would
+		// only earn a 416.
+		return io.NopCloser(strings.NewReader("")), true, nil
+	}
+
+	fileURL := fmt.Sprintf("%s/file/bot%s/%s", c.apiBase(), c.cfg.BotToken, strings.TrimPrefix(file.FilePath, "/"))
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	resp, err := c.get(ctx, fileURL, header)
+	if err != nil {
+		return nil, false, fmt.Errorf("download telegram file: %w", err)
+	}
+
+	resumed := offset > 0 && resp.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))
+	if offset > 0 && !resumed && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		slog.InfoContext(ctx, "range not served, restarting download", "file_id", fileID, "status", resp.StatusCode)
+		if resp, err = c.get(ctx, fileURL, nil); err != nil {
+			return nil, false, fmt.Errorf("download telegram file: %w", err)
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, false, &DownloadStatusError{StatusCode: resp.StatusCode}
+	}
+	start := int64(0)
+	if resumed {
+		start = offset
+	}
+	if start+resp.ContentLength > maxBytes {
+		resp.Body.Close()
+		return nil, false, ErrTooLarge
+	}
+	size := resp.ContentLength
+	if file.FileSize > 0 {
+		size = file.FileSize - start
+	}
+	if size < 0 {
+		return resp.Body, resumed, nil
+	}
+	return &sizedBody{ReadCloser: resp.Body, size: size}, resumed, nil
+}
+
+func (c *TelegramClient) getFile(ctx context.Context, fileID string) (telegramFile, error) {
+	endpoint := fmt.Sprintf("%s/bot%s/getFile?file_id=%s", c.apiBase(), c.cfg.BotToken, url.QueryEscape(fileID))
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return telegramFile{}, fmt.Errorf("telegram getFile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return telegramFile{}, fmt.Errorf("decode getFile response (%d): %w", resp.StatusCode, err)
+	}
+	if !body.OK {
+		return telegramFile{}, &TelegramAPIError{
+			Method:      "getFile",
+			Code:        body.ErrorCode,
+			Description: body.Description,
+			RetryAfter:  time.Duration(body.Parameters.RetryAfter) * time.Second,
+		}
+	}
+
+	var file telegramFile
+	if err := json.Unmarshal(body.Result, &file); err != nil {
+		return telegramFile{}, fmt.Errorf("decode getFile result: %w", err)
+	}
+	if file.FilePath == "" {
+		return telegramFile{}, fmt.Errorf("telegram getFile returned no file_path for %s", fileID)
+	}
+	return file, nil
+}
+
+// get issues a rate-limited GET with header added to the request. A 429 is
+// waited out for as long as Telegram asks and then retried; after
+// maxRateLimitRetries the 429 response is returned to the caller as is.
+func (c *TelegramClient) get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, redactURLError(err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, redactURLError(err)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= maxRateLimitRetries {
+			return resp, nil
+		}
+
+		wait := retryAfter(resp)
+		resp.Body.Close()
+		slog.WarnContext(ctx, "telegram rate limited, waiting", "retry_after", wait.String(), "attempt", attempt+1)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryAfter reads how long Telegram wants us to back off, preferring the
+// retry_after in the JSON body over the Retry-After header.
+func retryAfter(resp *http.Response) time.Duration {
+	var body telegramResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Parameters.RetryAfter > 0 {
+		return time.Duration(body.Parameters.RetryAfter) * time.Second
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return time.Second
+}
+
+// redactURLError strips the request URL, which embeds the bot token, from
+// transport errors before they reach logs.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s telegram: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
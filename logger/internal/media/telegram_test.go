@@ -0,0 +1,61 @@
+package media
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTelegramClientAPIBase(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/tg/bottoken/getFile":
+			w.Write([]byte(`{"ok":true,"result":{"file_path":"documents/file_1.pdf","file_size":9}}`))
+		case "/tg/file/bottoken/documents/file_1.pdf":
+			w.Write([]byte("%PDF-1.4\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("TELEGRAM_API_BASE", srv.URL+"/tg/")
+	base, err := loadAPIBase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewTelegramClient(TelegramConfig{APIBase: base, BotToken: "token", RPS: 100}, srv.Client())
+
+	body, err := c.DownloadTelegramFile(context.Background(), "f", 1<<20)
+	if err != nil {
+		t.Fatalf("DownloadTelegramFile: %v (requested %v)", err, paths)
+	}
+	if got := expectedSize(body, 1<<20); got != 9 {
+		t.Errorf("expected size = %d, want the file_size of 9", got)
+	}
+	if got := readDownload(t, body); got != "%PDF-1.4\n" {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestLoadAPIBase(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":                        defaultTelegramAPIBase,
+		"http://localhost:8081/":  "http://localhost:8081",
+		"https://bots.example/tg": "https://bots.example/tg",
+	} {
+		t.Setenv("TELEGRAM_API_BASE", raw)
+		if got, err := loadAPIBase(); err != nil || got != want {
+			t.Errorf("loadAPIBase(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"localhost:8081", "ftp://bots.example", "https://", "https://bots.example/?x=1"} {
+		t.Setenv("TELEGRAM_API_BASE", raw)
+		if _, err := loadAPIBase(); err == nil {
+			t.Errorf("loadAPIBase(%q) succeeded", raw)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders so any Telegram photo format decodes
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+const (
+	defaultThumbnailMaxDim = 320
+	thumbnailQuality       = 80
+
+	// maxThumbnailPixels keeps a small upload that declares huge dimensions
+	// from being decoded into gigabytes of pixels.
+	maxThumbnailPixels = 50_000_000
+)
+
+// GenerateThumbnail decodes an image and re-encodes it as a JPEG whose
+// longest side is at most maxDim, preserving the aspect ratio. Images that
+// are already small enough are re-encoded at their original size. Images
+// over maxThumbnailPixels are refused before they are decoded.
+func GenerateThumbnail(r io.Reader, maxDim int) ([]byte, error) {
+	if maxDim < 1 {
+		return nil, fmt.Errorf("invalid thumbnail size %d", maxDim)
+	}
+
+	// Read the header first, keeping what it consumed for the real decode.
+	var head bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("decode image: %dx%d image is too large to thumbnail", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return nil, errors.New("decode image: empty image")
+	}
+
+	dstW, dstH := w, h
+	if w > maxDim || h > maxDim {
+		if w >= h {
+			dstW, dstH = maxDim, max(1, h*maxDim/w)
+		} else {
+			dstW, dstH = max(1, w*maxDim/h), maxDim
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleBox(src, dstW, dstH), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleBox downsamples src to w x h by averaging every source pixel that
+// falls inside each destination pixel.
+func scaleBox(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*srcH/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*srcH/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*srcW/w
+			x1 := max(x0+1, b.Min.X+(x+1)*srcW/w)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,55 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestGenerateThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for x := range 640 {
+		img.Set(x, x%480, color.RGBA{R: 255, A: 255})
+	}
+	var src bytes.Buffer
+	if err := png.Encode(&src, img); err != nil {
+		t.Fatal(err)
+	}
+
+	thumb, err := GenerateThumbnail(&src, 320)
+	if err != nil {
+		t.Fatalf("GenerateThumbnail: %v", err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if cfg.Width != 320 || cfg.Height != 240 {
+		t.Errorf("thumbnail is %dx%d, want 320x240", cfg.Width, cfg.Height)
+	}
+}
+
+func TestGenerateThumbnailRefusesHugeDimensions(t *testing.T) {
+	// A tiny PNG whose header claims 60000x60000 pixels.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	ihdr := raw[8+4 : 8+4+4+13] // chunk type and data, after the signature and length
+	binary.BigEndian.PutUint32(ihdr[4:], 60000)
+	binary.BigEndian.PutUint32(ihdr[8:], 60000)
+	binary.BigEndian.PutUint32(raw[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+
+	if _, err := GenerateThumbnail(bytes.NewReader(raw), 320); err == nil {
+		t.Fatal("GenerateThumbnail decoded a 60000x60000 image")
+	} else if !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("err = %v, want the size check to refuse it", err)
+	}
+}
@@ -0,0 +1,68 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+// hangingDownloader returns bodies that never produce a byte until the
+// request context is cancelled, like a stalled Telegram fetch.
+type hangingDownloader struct{}
+
+func (hangingDownloader) DownloadTelegramFile(ctx context.Context, fileID string, maxBytes int64) (io.ReadCloser, error) {
+	return io.NopCloser(hangingReader{ctx}), nil
+}
+
+type hangingReader struct{ ctx context.Context }
+
+func (r hangingReader) Read(p []byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+func TestJobTimeout(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &recordingPublisher{}
+	m := metrics.New()
+	p := &mediaProcessor{
+		telegram:   hangingDownloader{},
+		storage:    storage,
+		dedup:      NewDedupCache(time.Minute),
+		publisher:  pub,
+		metrics:    m,
+		downloads:  TelegramConfig{MaxFileBytes: 1 << 20},
+		jobTimeout: 50 * time.Millisecond,
+	}
+
+	body, err := json.Marshal(MediaJob{
+		JobID:        "job-1",
+		ChatID:       42,
+		FileID:       "slow",
+		FileUniqueID: "slow",
+		MediaType:    MediaDocument,
+		RequestedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.handleMediaJob(context.Background(), "media.test", amqp.Delivery{Body: body}); err == nil {
+		t.Fatal("handleMediaJob succeeded, want a timeout error")
+	}
+	if got := testutil.ToFloat64(m.JobsFailed.WithLabelValues("media.test", "document", "false", StatusTimeout)); got != 1 {
+		t.Errorf("media_jobs_failed_total{status=timeout} = %v, want 1", got)
+	}
+	if len(pub.results) != 1 || pub.results[0].Status != StatusTimeout {
+		t.Errorf("results = %+v, want one %s result", pub.results, StatusTimeout)
+	}
+}
@@ -0,0 +1,150 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultResultsExchangeType = amqp.ExchangeTopic
+
+// loadExchangeType reads RESULTS_EXCHANGE_TYPE.
+func loadExchangeType() (string, error) {
+	switch kind := envString("RESULTS_EXCHANGE_TYPE", defaultResultsExchangeType); kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+		return kind, nil
+	default:
+		return "", fmt.Errorf("invalid RESULTS_EXCHANGE_TYPE: %q", kind)
+	}
+}
+
+// topologyChannel is the part of a channel DeclareTopology needs.
+type topologyChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Close() error
+}
+
+var _ topologyChannel = (*amqp.Channel)(nil)
+
+// topologyStep declares one exchange, queue or binding.
+type topologyStep struct {
+	what    string
+	declare func(ch topologyChannel) error
+}
+
+// DeclareTopology declares everything the service publishes to and consumes
+// from: the results exchange when one is set, the dead-letter exchange and
+// queue, and each media queue with its retry delay queues. When both the
+// results exchange and consumer.RoutingKey are set, every media queue is
+// bound to the exchange with that key, so jobs can be published there too;
+// Config.Validate makes sure the key, wildcards included, does not match the
+// results routing key, so results cannot end up on a media queue.
+//
+// Declaring is idempotent. An exchange or queue that already exists with a
+// different definition, say one provisioned by an operator, is left as it
+// is: RabbitMQ refuses the redeclare with PRECONDITION_FAILED and closes the
+// channel, so the step is logged and skipped and the rest carry on over a
+// new channel from open.
+func DeclareTopology(open func() (topologyChannel, error), consumer ConsumerConfig, results ResultsConfig) error {
+	var ch topologyChannel
+	defer func() {
+		if ch != nil {
+			ch.Close()
+		}
+	}()
+
+	for _, step := range topologySteps(consumer, results) {
+		if ch == nil {
+			var err error
+			if ch, err = open(); err != nil {
+				return fmt.Errorf("open channel: %w", err)
+			}
+		}
+
+		err := step.declare(ch)
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+			slog.Warn("keeping existing definition", "entity", step.what, "reason", amqpErr.Reason)
+			ch.Close()
+			ch = nil
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("declare %s: %w", step.what, err)
+		}
+	}
+	return nil
+}
+
+func topologySteps(consumer ConsumerConfig, results ResultsConfig) []topologyStep {
+	var steps []topologyStep
+	exchange := func(name, kind string) {
+		steps = append(steps, topologyStep{"exchange " + name, func(ch topologyChannel) error {
+			return ch.ExchangeDeclare(name, kind, true, false, false, false, nil)
+		}})
+	}
+	queue := func(name string, args amqp.Table) {
+		steps = append(steps, topologyStep{"queue " + name, func(ch topologyChannel) error {
+			_, err := ch.QueueDeclare(name, true, false, false, false, args)
+			return err
+		}})
+	}
+	bind := func(name, key, exchange string) {
+		steps = append(steps, topologyStep{"binding of " + name + " to " + exchange, func(ch topologyChannel) error {
+			return ch.QueueBind(name, key, exchange, false, nil)
+		}})
+	}
+
+	if results.Exchange != "" {
+		exchange(results.Exchange, results.ExchangeType)
+	}
+
+	dlq := deadLetterQueue(consumer.DLX)
+	exchange(consumer.DLX, amqp.ExchangeFanout)
+	queue(dlq, nil)
+	bind(dlq, "", consumer.DLX)
+
+	for _, q := range consumer.Queues {
+		queue(q.Name, mediaQueueArgs(consumer))
+		if consumer.RetryDelay > 0 {
+			for attempt := 1; attempt <= consumer.MaxRetries; attempt++ {
+				queue(retryQueue(q.Name, attempt), retryQueueArgs(q.Name, attempt, consumer))
+			}
+		}
+		if results.Exchange != "" && consumer.RoutingKey != "" {
+			bind(q.Name, consumer.RoutingKey, results.Exchange)
+		}
+	}
+	return steps
+}
+
+// topicMatches reports whether a topic exchange would route key to a queue
+// bound with pattern, where "*" stands for one dot-separated word and "#"
+// for any number of them.
+func topicMatches(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && key[0] == pattern[0] && matchWords(pattern[1:], key[1:])
+	}
+}
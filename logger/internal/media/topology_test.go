@@ -0,0 +1,119 @@
+package media
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeTopology records declarations and fails the ones named in conflicts
+// with PRECONDITION_FAILED, as RabbitMQ does for an entity that exists with
+// a different definition, then refuses further use of that channel.
+type fakeTopology struct {
+	conflicts map[string]bool
+	opens     int
+	closed    bool
+	exchanges map[string]string
+	queues    []string
+	bindings  []string
+}
+
+func (f *fakeTopology) open() (topologyChannel, error) {
+	f.opens++
+	f.closed = false
+	return f, nil
+}
+
+func (f *fakeTopology) check(name string) error {
+	if f.closed {
+		return amqp.ErrClosed
+	}
+	if f.conflicts[name] {
+		f.closed = true
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - inequivalent arg"}
+	}
+	return nil
+}
+
+func (f *fakeTopology) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	if err := f.check(name); err != nil {
+		return err
+	}
+	f.exchanges[name] = kind
+	return nil
+}
+
+func (f *fakeTopology) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if err := f.check(name); err != nil {
+		return amqp.Queue{}, err
+	}
+	f.queues = append(f.queues, name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (f *fakeTopology) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	if f.closed {
+		return amqp.ErrClosed
+	}
+	f.bindings = append(f.bindings, exchange+"/"+key+"->"+name)
+	return nil
+}
+
+func (f *fakeTopology) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestDeclareTopology(t *testing.T) {
+	consumer := ConsumerConfig{
+		Queues:     []QueueConfig{{Name: "media.fast"}, {Name: "media.slow"}},
+		RoutingKey: "media.jobs",
+		DLX:        "media.dead",
+	}
+	results := ResultsConfig{Exchange: "bot", ExchangeType: amqp.ExchangeTopic}
+
+	f := &fakeTopology{conflicts: map[string]bool{"media.fast": true}, exchanges: map[string]string{}}
+	if err := DeclareTopology(f.open, consumer, results); err != nil {
+		t.Fatalf("DeclareTopology: %v", err)
+	}
+
+	if f.exchanges["bot"] != amqp.ExchangeTopic || f.exchanges["media.dead"] != amqp.ExchangeFanout {
+		t.Errorf("exchanges = %v", f.exchanges)
+	}
+	if f.opens != 2 {
+		t.Errorf("opened %d channels, want a fresh one after the conflict", f.opens)
+	}
+	if want := []string{"media.dead.queue", "media.slow"}; !slices.Equal(f.queues, want) {
+		t.Errorf("declared queues %v, want %v", f.queues, want)
+	}
+	want := []string{"media.dead/->media.dead.queue", "bot/media.jobs->media.fast", "bot/media.jobs->media.slow"}
+	if !slices.Equal(f.bindings, want) {
+		t.Errorf("bindings %v, want %v", f.bindings, want)
+	}
+}
+
+func TestDeclareTopologyWithoutResultsExchange(t *testing.T) {
+	consumer := ConsumerConfig{Queues: []QueueConfig{{Name: "media.process"}}, RoutingKey: "media.jobs", DLX: "media.dead"}
+	f := &fakeTopology{exchanges: map[string]string{}}
+	if err := DeclareTopology(f.open, consumer, ResultsConfig{ExchangeType: amqp.ExchangeTopic}); err != nil {
+		t.Fatalf("DeclareTopology: %v", err)
+	}
+	if _, ok := f.exchanges[""]; ok || len(f.exchanges) != 1 {
+		t.Errorf("exchanges = %v, want only the dead-letter exchange", f.exchanges)
+	}
+	if len(f.bindings) != 1 {
+		t.Errorf("bindings %v, want only the dead-letter binding", f.bindings)
+	}
+}
+
+func TestDeclareTopologyFailsOnOtherErrors(t *testing.T) {
+	consumer := ConsumerConfig{Queues: []QueueConfig{{Name: "media.process"}}, DLX: "media.dead"}
+	err := DeclareTopology(func() (topologyChannel, error) {
+		return &fakeTopology{closed: true}, nil
+	}, consumer, ResultsConfig{})
+	if !errors.Is(err, amqp.ErrClosed) {
+		t.Fatalf("err = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,53 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// VerifyReport counts what VerifyStored looked at.
+type VerifyReport struct {
+	Checked    int
+	Skipped    int
+	Mismatched int
+}
+
+// VerifyStored re-hashes every file the storage index has a checksum for
+// and logs each one that is missing or no longer matches. Records written
+// before checksums were kept are skipped.
+func VerifyStored(ctx context.Context, storage Storage) (VerifyReport, error) {
+	var report VerifyReport
+	keys, err := storage.List(ctx, "index/")
+	if err != nil {
+		return report, err
+	}
+
+	for _, indexKey := range keys {
+		record, err := readRecord(ctx, storage, indexKey)
+		if err != nil {
+			return report, err
+		}
+		if record.Key == "" || record.SHA256 == "" {
+			report.Skipped++
+			continue
+		}
+
+		report.Checked++
+		ok, err := storage.Verify(ctx, record.Key, record.SHA256)
+		if errors.Is(err, ErrNotFound) {
+			report.Mismatched++
+			slog.WarnContext(ctx, "stored file missing", "file_unique_id", record.FileUniqueID, "key", record.Key)
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("verify %s: %w", record.Key, err)
+		}
+		if !ok {
+			report.Mismatched++
+			slog.WarnContext(ctx, "stored file checksum mismatch", "file_unique_id", record.FileUniqueID, "key", record.Key, "sha256", record.SHA256)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,76 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestChecksumAndVerify(t *testing.T) {
+	const body = "%PDF-1.4\nchecksummed"
+	sum := sha256.Sum256([]byte(body))
+	want := hex.EncodeToString(sum[:])
+
+	root := t.TempDir()
+	storage, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mediaProcessor{
+		telegram:         fakeDownloader{"doc": body},
+		storage:          storage,
+		dedup:            NewDedupCache(time.Minute),
+		index:            NewStorageIndex(storage),
+		metrics:          metrics.New(),
+		downloads:        TelegramConfig{MaxFileBytes: 1 << 20},
+		allowedMIMETypes: defaultAllowedMIMETypes,
+	}
+
+	ctx := context.Background()
+	job := MediaJob{JobID: "job-1", FileID: "doc", FileUniqueID: "doc-1", MediaType: MediaDocument, RequestedAt: time.Now()}
+	result, err := p.process(ctx, job)
+	if err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if result.SHA256 != want {
+		t.Fatalf("result sha256 = %q, want %q", result.SHA256, want)
+	}
+	record, ok, err := p.index.Get(ctx, "doc-1")
+	if err != nil || !ok {
+		t.Fatalf("index.Get = %v, %v", ok, err)
+	}
+	if record.SHA256 != want {
+		t.Fatalf("record sha256 = %q, want %q", record.SHA256, want)
+	}
+
+	if ok, err := storage.Verify(ctx, record.Key, want); err != nil || !ok {
+		t.Fatalf("Verify = %v, %v; want true", ok, err)
+	}
+	report, err := VerifyStored(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (VerifyReport{Checked: 1}) {
+		t.Fatalf("report = %+v, want one clean file", report)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(record.Key)), []byte("bit rot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := storage.Verify(ctx, record.Key, want); err != nil || ok {
+		t.Fatalf("Verify after corruption = %v, %v; want false", ok, err)
+	}
+	report, err = VerifyStored(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (VerifyReport{Checked: 1, Mismatched: 1}) {
+		t.Fatalf("report = %+v, want one mismatch", report)
+	}
+}
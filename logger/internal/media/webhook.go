@@ -0,0 +1,166 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+const (
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookMaxRetries = 2
+
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookConfig describes the optional completion callback. An empty URL
+// turns it off.
+type WebhookConfig struct {
+	URL        string
+	Secret     string
+	Timeout    time.Duration
+	MaxRetries int
+}
+
+// LoadWebhookConfig reads COMPLETION_WEBHOOK_URL, WEBHOOK_SECRET,
+// WEBHOOK_TIMEOUT and WEBHOOK_MAX_RETRIES.
+func LoadWebhookConfig() (WebhookConfig, error) {
+	timeout, timeoutErr := envDuration("WEBHOOK_TIMEOUT", defaultWebhookTimeout)
+	retries, retriesErr := envInt("WEBHOOK_MAX_RETRIES", defaultWebhookMaxRetries, 0)
+	return WebhookConfig{
+		URL:        os.Getenv("COMPLETION_WEBHOOK_URL"),
+		Secret:     os.Getenv("WEBHOOK_SECRET"),
+		Timeout:    timeout,
+		MaxRetries: retries,
+	}, errors.Join(timeoutErr, retriesErr)
+}
+
+// Validate checks that a configured webhook URL is absolute http(s).
+func (cfg WebhookConfig) Validate() error {
+	if cfg.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid COMPLETION_WEBHOOK_URL: %q", cfg.URL)
+	}
+	return nil
+}
+
+// webhookPublisher publishes results as usual and then POSTs each one to
+// the completion webhook. The RabbitMQ result is the source of truth, so a
+// webhook that cannot be reached is logged and counted but never fails the
+// job.
+type webhookPublisher struct {
+	ResultPublisher
+
+	cfg     WebhookConfig
+	client  *http.Client
+	metrics *metrics.Metrics
+	backoff func(attempt int) time.Duration
+}
+
+// NewWebhookPublisher adds the completion webhook to next, or returns next
+// unchanged when no webhook is configured.
+func NewWebhookPublisher(next ResultPublisher, cfg WebhookConfig, client *http.Client, m *metrics.Metrics) ResultPublisher {
+	if cfg.URL == "" {
+		return next
+	}
+	return &webhookPublisher{ResultPublisher: next, cfg: cfg, client: client, metrics: m, backoff: downloadBackoff}
+}
+
+func (w *webhookPublisher) PublishResult(ctx context.Context, result MediaResult) error {
+	if err := w.ResultPublisher.PublishResult(ctx, result); err != nil {
+		return err
+	}
+	if result.CorrelationID == "" {
+		result.CorrelationID = correlationID(ctx)
+	}
+	if err := w.notify(ctx, result); err != nil {
+		w.metrics.WebhookFailures.Inc()
+		slog.WarnContext(ctx, "completion webhook failed", "job_id", result.JobID, "error", err)
+	}
+	return nil
+}
+
+// notify POSTs result, retrying on 5xx and network errors.
+func (w *webhookPublisher) notify(ctx context.Context, result MediaResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("marshal result for %s: %w", result.JobID, err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= w.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(w.backoff(attempt - 1)):
+			case <-ctx.Done():
+				return errors.Join(lastErr, ctx.Err())
+			}
+		}
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// post makes one webhook call and reports whether a failure is worth
+// retrying.
+func (w *webhookPublisher) post(ctx context.Context, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.cfg.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhook(w.cfg.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	// Drain so the connection goes back to the pool.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return false, nil
+	case resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+}
+
+// signWebhook is the X-Webhook-Signature value for body: "sha256=" and the
+// hex HMAC-SHA256 of the raw body keyed by secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
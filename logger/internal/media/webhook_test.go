@@ -0,0 +1,74 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zxeenu/heavy-telegram-bot/logger/metrics"
+)
+
+func TestWebhookPublisher(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantCalls    int32
+		wantFailures float64
+	}{
+		{name: "delivered", statuses: []int{200}, wantCalls: 1},
+		{name: "retried after 5xx", statuses: []int{503, 502, 204}, wantCalls: 3},
+		{name: "5xx until retries run out", statuses: []int{500, 500, 500}, wantCalls: 3, wantFailures: 1},
+		{name: "4xx is not retried", statuses: []int{400}, wantCalls: 1, wantFailures: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				body, _ := io.ReadAll(r.Body)
+				if got, want := r.Header.Get(webhookSignatureHeader), signWebhook("s3cret", body); got != want {
+					t.Errorf("signature = %q, want %q", got, want)
+				}
+				var result MediaResult
+				if err := json.Unmarshal(body, &result); err != nil || result.JobID != "job-1" {
+					t.Errorf("body = %s (%v)", body, err)
+				}
+				w.WriteHeader(tt.statuses[min(int(n), len(tt.statuses))-1])
+			}))
+			defer srv.Close()
+
+			m := metrics.New()
+			rabbit := &recordingPublisher{}
+			cfg := WebhookConfig{URL: srv.URL, Secret: "s3cret", Timeout: time.Second, MaxRetries: 2}
+			pub := NewWebhookPublisher(rabbit, cfg, srv.Client(), m).(*webhookPublisher)
+			pub.backoff = func(int) time.Duration { return 0 }
+
+			if err := pub.PublishResult(context.Background(), MediaResult{JobID: "job-1", Status: StatusStored}); err != nil {
+				t.Fatalf("PublishResult = %v; webhook failures must not fail the job", err)
+			}
+			if len(rabbit.results) != 1 {
+				t.Errorf("published %d results to rabbitmq, want 1", len(rabbit.results))
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("webhook called %d times, want %d", got, tt.wantCalls)
+			}
+			if got := testutil.ToFloat64(m.WebhookFailures); got != tt.wantFailures {
+				t.Errorf("media_webhook_failures_total = %v, want %v", got, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// echo -n '{"job_id":"j"}' | openssl dgst -sha256 -hmac key
+	const want = "sha256=eb25aedd10ceeb9869e3ee857a90de48f9186676dfc38e84ecd9759363983710"
+	if got := signWebhook("key", []byte(`{"job_id":"j"}`)); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+// Package metrics holds the Prometheus collectors for the media pipeline.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics groups every collector the service exports.
+type Metrics struct {
+	JobsTotal    *prometheus.CounterVec
+	JobsFailed   *prometheus.CounterVec
+	JobDuration  *prometheus.HistogramVec
+	InflightJobs *prometheus.GaugeVec
+	JobsExpired  *prometheus.CounterVec
+	JobsLimited  *prometheus.CounterVec
+	QueueDepth   *prometheus.GaugeVec
+
+	WebhookFailures prometheus.Counter
+	InflightBytes   prometheus.Gauge
+
+	gatherer prometheus.Gatherer
+}
+
+// New creates the collectors and registers them on a fresh registry, so
+// tests can build as many independent instances as they like.
+func New() *Metrics {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	m := &Metrics{
+		JobsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "media_jobs_total",
+			Help: "Media jobs processed, successful or not.",
+		}, []string{"queue", "media_type", "dry_run"}),
+		JobsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "media_jobs_failed_total",
+			Help: "Media jobs that did not complete, by failure status.",
+		}, []string{"queue", "media_type", "dry_run", "status"}),
+		JobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "media_job_duration_seconds",
+			Help:    "Time spent processing a media job.",
+			Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"queue", "media_type", "dry_run"}),
+		InflightJobs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "media_inflight_jobs",
+			Help: "Media jobs currently being processed.",
+		}, []string{"queue", "media_type", "dry_run"}),
+		JobsExpired: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "media_jobs_expired_total",
+			Help: "Media jobs dropped unprocessed because they exceeded MAX_JOB_AGE.",
+		}, []string{"queue", "media_type", "dry_run"}),
+		JobsLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "media_jobs_rate_limited_total",
+			Help: "Media jobs dropped unprocessed because their chat exceeded PER_CHAT_LIMIT.",
+		}, []string{"queue", "media_type", "dry_run"}),
+		QueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "media_queue_depth",
+			Help: "Messages ready for delivery on each consumed queue.",
+		}, []string{"queue"}),
+		WebhookFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "media_webhook_failures_total",
+			Help: "Completion webhook calls that failed after all retries.",
+		}),
+		InflightBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "media_inflight_bytes",
+			Help: "Bytes of media reserved by downloads in progress, out of MAX_INFLIGHT_BYTES.",
+		}),
+		gatherer: reg,
+	}
+
+	reg.MustRegister(m.JobsTotal, m.JobsFailed, m.JobDuration, m.InflightJobs, m.JobsExpired, m.JobsLimited, m.QueueDepth, m.WebhookFailures, m.InflightBytes)
+	return m
+}
+
+// JobStarted marks a job from queue as in flight and returns a func that
+// records its outcome. Pass an empty status on success. dryRun separates
+// jobs that were only parsed and logged from real ones.
+func (m *Metrics) JobStarted(queue, mediaType string, dryRun bool) func(status string) {
+	started := time.Now()
+	dry := strconv.FormatBool(dryRun)
+	m.InflightJobs.WithLabelValues(queue, mediaType, dry).Inc()
+
+	return func(status string) {
+		m.InflightJobs.WithLabelValues(queue, mediaType, dry).Dec()
+		m.JobsTotal.WithLabelValues(queue, mediaType, dry).Inc()
+		m.JobDuration.WithLabelValues(queue, mediaType, dry).Observe(time.Since(started).Seconds())
+		if status != "" {
+			m.JobsFailed.WithLabelValues(queue, mediaType, dry, status).Inc()
+		}
+	}
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
+}
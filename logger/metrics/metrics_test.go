@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJobStartedRecordsOutcome(t *testing.T) {
+	m := New()
+
+	done := m.JobStarted("media.process", "photo", false)
+	if got := testutil.ToFloat64(m.InflightJobs.WithLabelValues("media.process", "photo", "false")); got != 1 {
+		t.Fatalf("inflight during job = %v, want 1", got)
+	}
+	done("")
+
+	m.JobStarted("media.process", "photo", false)("failed")
+
+	if got := testutil.ToFloat64(m.JobsTotal.WithLabelValues("media.process", "photo", "false")); got != 2 {
+		t.Errorf("media_jobs_total = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.JobsFailed.WithLabelValues("media.process", "photo", "false", "failed")); got != 1 {
+		t.Errorf("media_jobs_failed_total = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.InflightJobs.WithLabelValues("media.process", "photo", "false")); got != 0 {
+		t.Errorf("inflight after jobs = %v, want 0", got)
+	}
+	if got := testutil.CollectAndCount(m.JobDuration); got != 1 {
+		t.Errorf("duration series = %d, want 1", got)
+	}
+}